@@ -0,0 +1,86 @@
+package algorithms
+
+import (
+	"hash/crc32"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang-load-balancer/backend"
+)
+
+// replicasPerBackend is the number of points a backend of weight 1 gets on the
+// ring, more points spread keys more evenly. Heavier backends get proportionally more.
+const replicasPerBackend = 100
+
+// ConsistentHash maps client keys onto a hash ring so that adding or removing
+// a backend only moves the keys that belonged to it
+type ConsistentHash struct {
+	backends []*backend.Backend
+	ring     []uint32
+	owners   map[uint32]*backend.Backend
+	mutex    sync.RWMutex
+}
+
+func NewConsistentHash(backends []*backend.Backend) *ConsistentHash {
+	ch := &ConsistentHash{}
+	ch.UpdateBackends(backends)
+	return ch
+}
+
+func (ch *ConsistentHash) GetStrategyType() StrategyType {
+	return ConsistentHashStrategy
+}
+
+func (ch *ConsistentHash) UpdateBackends(backends []*backend.Backend) {
+	ring := make([]uint32, 0, len(backends)*replicasPerBackend)
+	owners := make(map[uint32]*backend.Backend, len(backends)*replicasPerBackend)
+
+	for _, b := range backends {
+		replicas := replicasPerBackend * max(b.Weight, 1)
+		for i := 0; i < replicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(b.URL.String() + "#" + strconv.Itoa(i)))
+			if _, taken := owners[point]; taken {
+				continue
+			}
+			owners[point] = b
+			ring = append(ring, point)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	ch.backends = backends
+	ch.ring = ring
+	ch.owners = owners
+}
+
+// GetNextBackend is used when no client key is known, all such requests share one backend
+func (ch *ConsistentHash) GetNextBackend() *backend.Backend {
+	return ch.GetNextBackendForKey("")
+}
+
+// GetNextBackendForKey walks the ring clockwise from the key's hash to the first healthy backend
+func (ch *ConsistentHash) GetNextBackendForKey(key string) *backend.Backend {
+	ch.mutex.RLock()
+	defer ch.mutex.RUnlock()
+
+	n := len(ch.ring)
+	if n == 0 {
+		return nil
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(n, func(i int) bool { return ch.ring[i] >= hash })
+
+	for i := 0; i < n; i++ {
+		b := ch.owners[ch.ring[(start+i)%n]]
+		if b.IsAlive() {
+			log.Printf("Consistent hashing selected backend: %s for key: %s", b.URL.String(), key)
+			return b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package algorithms
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"golang-load-balancer/backend"
+)
+
+func newTestBackends(weights ...int) []*backend.Backend {
+	var backends []*backend.Backend
+	for i, w := range weights {
+		u, _ := url.Parse(fmt.Sprintf("http://10.0.0.%d:8080", i+1))
+		backends = append(backends, &backend.Backend{URL: u, Alive: true, Weight: w})
+	}
+	return backends
+}
+
+// assign maps many client keys to the backend the ring picks for them
+func assign(ch *ConsistentHash) map[string]*backend.Backend {
+	owners := make(map[string]*backend.Backend)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("192.0.2.%d:%d", i%256, i)
+		owners[key] = ch.GetNextBackendForKey(key)
+	}
+	return owners
+}
+
+func TestConsistentHashAddBackend(t *testing.T) {
+	backends := newTestBackends(1, 1, 1, 1, 1, 1)
+	ch := NewConsistentHash(backends[:5])
+	before := assign(ch)
+
+	ch.UpdateBackends(backends)
+	after := assign(ch)
+
+	moved := 0
+	for key, b := range before {
+		if after[key] == b {
+			continue
+		}
+		moved++
+		if after[key] != backends[5] {
+			t.Fatalf("key %s moved from %s to %s instead of the new backend", key, b.URL, after[key].URL)
+		}
+	}
+	// about a sixth of the keys belong to the new backend
+	if share := float64(moved) / float64(len(before)); share < 0.08 || share > 0.3 {
+		t.Errorf("%.0f%% of keys moved to the new backend, want about 17%%", share*100)
+	}
+}
+
+func TestConsistentHashRemoveBackend(t *testing.T) {
+	backends := newTestBackends(1, 1, 1, 1)
+	ch := NewConsistentHash(backends)
+	before := assign(ch)
+
+	removed := backends[2]
+	ch.UpdateBackends([]*backend.Backend{backends[0], backends[1], backends[3]})
+	after := assign(ch)
+
+	for key, b := range before {
+		if b != removed && after[key] != b {
+			t.Fatalf("key %s moved off %s although only %s was removed", key, b.URL, removed.URL)
+		}
+		if after[key] == removed {
+			t.Fatalf("key %s still maps to the removed backend", key)
+		}
+	}
+}
+
+func TestConsistentHashSkipsDeadBackends(t *testing.T) {
+	backends := newTestBackends(1, 1, 1)
+	ch := NewConsistentHash(backends)
+	before := assign(ch)
+
+	backends[0].SetAlive(false)
+	after := assign(ch)
+	for key, b := range before {
+		if b != backends[0] && after[key] != b {
+			t.Fatalf("key %s moved off healthy backend %s", key, b.URL)
+		}
+		if after[key] == backends[0] {
+			t.Fatalf("key %s maps to a dead backend", key)
+		}
+	}
+
+	for _, b := range backends {
+		b.SetAlive(false)
+	}
+	if b := ch.GetNextBackendForKey("x"); b != nil {
+		t.Errorf("got %s with every backend down", b.URL)
+	}
+	if b := NewConsistentHash(nil).GetNextBackendForKey("x"); b != nil {
+		t.Errorf("empty ring returned %s", b.URL)
+	}
+}
+
+func TestConsistentHashWeights(t *testing.T) {
+	tests := []struct {
+		weights []int
+		want    []float64 // expected share of keys per backend
+	}{
+		{[]int{1, 1}, []float64{0.5, 0.5}},
+		{[]int{3, 1}, []float64{0.75, 0.25}},
+		{[]int{0, 1}, []float64{0.5, 0.5}}, // unset weights count as 1
+		{[]int{1, 2, 1}, []float64{0.25, 0.5, 0.25}},
+	}
+	for _, tt := range tests {
+		backends := newTestBackends(tt.weights...)
+		owners := assign(NewConsistentHash(backends))
+
+		counts := make(map[*backend.Backend]int)
+		for _, b := range owners {
+			counts[b]++
+		}
+		for i, b := range backends {
+			share := float64(counts[b]) / float64(len(owners))
+			if share < tt.want[i]-0.12 || share > tt.want[i]+0.12 {
+				t.Errorf("weights %v: backend %d got %.2f of keys, want about %.2f", tt.weights, i, share, tt.want[i])
+			}
+		}
+	}
+}
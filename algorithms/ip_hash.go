@@ -3,9 +3,6 @@ package algorithms
 import (
 	"hash/crc32"
 	"log"
-	"net"
-	"net/http"
-	"strings"
 
 	"golang-load-balancer/backend"
 )
@@ -26,64 +23,31 @@ func (h *IPHash) UpdateBackends(backends []*backend.Backend) {
 	h.backends = backends
 }
 
-// GetNextBackend returns the same backend for same hash value
+// GetNextBackend is used when no client key is known, all such requests share one backend
 func (ip *IPHash) GetNextBackend() *backend.Backend {
-	// Get real client IP from request context (you would get this in a real scenario)
-	clientIP := "192.168.1.100"
-
-	// Hash the client IP address using CRC32 to determine the backend
-	hash := crc32.ChecksumIEEE([]byte(clientIP))
-	index := int(hash % uint32(len(ip.backends)))
-
-	// Return the selected backend
-	selectedBackend := ip.backends[index]
-	log.Printf("IP Hashing selected backend: %s for client IP: %s", selectedBackend.URL.String(), clientIP)
-	return selectedBackend
+	return ip.GetNextBackendForKey("")
 }
 
-// for sticky session for each client
-// func (ih *IPHash) GetNextBackend(r *http.Request) *backend.Backend {
-// 	// Get client IP from request
-// 	clientIP := getClientIP(r)
-
-// 	// Use a simple hash function (you can replace this with a more complex one if needed)
-// 	hash := hashIP(clientIP)
-
-// 	// Get backend based on hashed value
-// 	index := hash % len(ih.backends)
-// 	backend := ih.backends[index]
-
-// 	log.Printf("Routing request from IP %s to backend %s", clientIP, backend.URL.String())
-// 	return backend
-// }
-
-// getClientIP retrieves the client IP from the HTTP request.
-func getClientIP(r *http.Request) string {
-	// Check for X-Forwarded-For header if behind a proxy
-	xForwardedFor := r.Header.Get("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// The X-Forwarded-For header can have multiple IPs, use the first one
-		ips := strings.Split(xForwardedFor, ",")
-		return strings.TrimSpace(ips[0])
-	}
-
-	// Fallback to RemoteAddr if no X-Forwarded-For header
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		log.Println("Error parsing RemoteAddr:", err)
-		return ""
+// GetNextBackendForKey returns the same backend for same hash value
+func (ip *IPHash) GetNextBackendForKey(clientIP string) *backend.Backend {
+	n := len(ip.backends)
+	if n == 0 {
+		return nil
 	}
 
-	return host
-}
-
-// A simple hash function (you can replace it with a more complex hash like MD5/SHA256 if needed)
-func hashIP(clientIP string) int {
-	var hash int
-	for i := 0; i < len(clientIP); i++ {
-		hash = 31*hash + int(clientIP[i])
+	// Hash the client IP address using CRC32 to determine the backend
+	hash := crc32.ChecksumIEEE([]byte(clientIP))
+	index := int(hash % uint32(n))
+
+	// Skip forward past unhealthy backends so the client still gets served
+	for i := 0; i < n; i++ {
+		selectedBackend := ip.backends[(index+i)%n]
+		if selectedBackend.IsAlive() {
+			log.Printf("IP Hashing selected backend: %s for client IP: %s", selectedBackend.URL.String(), clientIP)
+			return selectedBackend
+		}
 	}
-	return hash
+	return nil
 }
 
 /*
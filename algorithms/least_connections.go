@@ -42,8 +42,7 @@ func (lc *LeastConnections) GetNextBackend() *backend.Backend {
 		}
 	}
 	if best != nil {
-		best.IncrementConnections()
-		log.Printf("Best Server %s has %d active connections", best.URL.String(), best.ActiveConnections)
+		log.Printf("Best Server %s has %d active connections", best.URL.String(), minConnections)
 	}
 	return best
 }
@@ -13,6 +13,7 @@ const (
 	WeightedRoundRobinStrategy StrategyType = "weighted_round_robin"
 	LeastConnectionsStrategy   StrategyType = "least_connections"
 	IPHashStrategy             StrategyType = "ip_hash"
+	ConsistentHashStrategy     StrategyType = "consistent_hash"
 )
 
 type Strategy interface {
@@ -23,6 +24,12 @@ type Strategy interface {
 
 // Any struct that has a GetNextBackend() method with this exact signature can be treated as a Strategy.
 
+// KeyedStrategy is implemented by strategies that pick a backend from a client key,
+// such as IP hashing, so the same client keeps landing on the same backend.
+type KeyedStrategy interface {
+	GetNextBackendForKey(key string) *backend.Backend
+}
+
 func NewStrategy(strategy StrategyType, backends []*backend.Backend) Strategy {
 	switch strategy {
 	case RoundRobinStrategy:
@@ -33,9 +40,11 @@ func NewStrategy(strategy StrategyType, backends []*backend.Backend) Strategy {
 		return NewLeastConnections(backends)
 	case IPHashStrategy:
 		return NewIPHash(backends)
+	case ConsistentHashStrategy:
+		return NewConsistentHash(backends)
 
 	default:
-		log.Fatal("Invalid algorithm. Use: rr, wrr, ip, lc")
+		log.Fatal("Invalid algorithm. Use: rr, wrr, ip, lc, ch")
 		return nil
 	}
 }
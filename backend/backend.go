@@ -3,9 +3,11 @@ package backend
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,12 +17,26 @@ type Backend struct {
 	Weight            int
 	CurrentWeight     int
 	ActiveConnections int
+	Draining          bool     // takes no new traffic while existing connections finish
+	Disabled          bool     // taken out of rotation by an operator, health checks don't bring it back
+	Tags              []string // free-form labels set through the admin API
 
-	mutex           sync.RWMutex // for Alive status
+	mutex           sync.RWMutex // for Alive, Draining, Disabled and Tags
 	ActiveConnMutex sync.RWMutex // for ActiveConnections
+
+	requests atomic.Int64 // responses received from the backend
+	failures atomic.Int64 // 5xx responses and failed connections
 }
 
+// IsAlive reports whether the backend can take new traffic, draining and disabled backends can't
 func (b *Backend) IsAlive() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.Alive && !b.Draining && !b.Disabled
+}
+
+// IsHealthy reports the result of the last health check, whether or not the backend takes traffic
+func (b *Backend) IsHealthy() bool {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
 	return b.Alive
@@ -32,6 +48,55 @@ func (b *Backend) SetAlive(alive bool) {
 	b.Alive = alive
 }
 
+func (b *Backend) SetDraining(draining bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Draining = draining
+}
+
+func (b *Backend) IsDraining() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.Draining
+}
+
+func (b *Backend) SetDisabled(disabled bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Disabled = disabled
+}
+
+func (b *Backend) IsDisabled() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.Disabled
+}
+
+func (b *Backend) SetTags(tags []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Tags = append([]string(nil), tags...)
+}
+
+func (b *Backend) GetTags() []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return append([]string{}, b.Tags...)
+}
+
+// RecordRequest counts a proxied request and whether the backend failed it
+func (b *Backend) RecordRequest(failed bool) {
+	b.requests.Add(1)
+	if failed {
+		b.failures.Add(1)
+	}
+}
+
+// Stats returns the number of proxied requests and how many of them failed
+func (b *Backend) Stats() (requests, failures int64) {
+	return b.requests.Load(), b.failures.Load()
+}
+
 func (b *Backend) IncrementConnections() {
 	b.ActiveConnMutex.Lock()
 	defer b.ActiveConnMutex.Unlock()
@@ -52,15 +117,32 @@ func (b *Backend) GetConnections() int {
 	return b.ActiveConnections
 }
 
-func CheckBackendHealth(u *url.URL) bool {
-	resp, err := http.Get(u.String() + "/health")
-	if err != nil || resp.StatusCode != http.StatusOK {
+func CheckBackendHealth(client *http.Client, u *url.URL) bool {
+	resp, err := client.Get(u.String() + "/health")
+	if err != nil {
+		log.Printf("Health check failed for %s: %v", u.String(), err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
 		log.Printf("Health check failed for %s", u.String())
 		return false
 	}
 	return true
 }
 
+// CheckTCPHealth reports whether a TCP connection to addr can be opened
+func CheckTCPHealth(addr string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		log.Printf("TCP health check failed for %s: %v", addr, err)
+		return false
+	}
+	conn.Close()
+	return true
+}
+
 // RunServers starts multiple dummy servers using the given backend instances
 func RunServers(basePort int, backends []*Backend) {
 	if len(backends) > 10 {
@@ -97,21 +179,32 @@ func StartServer(port int, serverID int, b *Backend, wg *sync.WaitGroup) {
 		time.Sleep(2 * time.Second)
 
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "Response from Server %d", serverID)
+		fmt.Fprintf(w, "Response from Server %d (%s)", serverID, r.Proto)
 	})
 
 	// Configure server
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("Starting server %d on %s", serverID, addr)
 
+	// Accept h2c as well so the load balancer can talk HTTP/2 to the dummy servers
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
 	server := http.Server{
-		Addr:    addr,
-		Handler: router,
+		Addr:      addr,
+		Handler:   router,
+		Protocols: protocols,
 	}
 
 	// Handler functions
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		if b.IsAlive() {
+		// draining is a load balancer decision, the server itself is still up
+		b.mutex.RLock()
+		alive := b.Alive
+		b.mutex.RUnlock()
+
+		if alive {
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(w, "Server %d is healthy", serverID)
 		} else {
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// grpcServing is the SERVING value of grpc.health.v1.HealthCheckResponse.status
+const grpcServing = 1
+
+// CheckGRPCHealth calls grpc.health.v1.Health/Check on the backend. The client
+// has to speak HTTP/2 to the backend (h2 or h2c). An empty service asks about
+// the server as a whole.
+func CheckGRPCHealth(client *http.Client, u *url.URL, service string) bool {
+	req, err := http.NewRequest(http.MethodPost, u.String()+"/grpc.health.v1.Health/Check", bytes.NewReader(healthCheckRequest(service)))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("gRPC health check failed for %s: %v", u.String(), err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("gRPC health check failed for %s: %v", u.String(), err)
+		return false
+	}
+
+	// trailers-only responses carry grpc-status in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		log.Printf("gRPC health check failed for %s: grpc-status %q", u.String(), status)
+		return false
+	}
+
+	if parseHealthResponse(body) != grpcServing {
+		log.Printf("gRPC health check failed for %s: not serving", u.String())
+		return false
+	}
+	return true
+}
+
+// healthCheckRequest frames HealthCheckRequest{service = 1}, encoded by hand to
+// avoid a protobuf dependency
+func healthCheckRequest(service string) []byte {
+	msg := []byte{0x0A}
+	msg = binary.AppendUvarint(msg, uint64(len(service)))
+	msg = append(msg, service...)
+
+	frame := []byte{0} // not compressed
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// parseHealthResponse unframes a HealthCheckResponse and returns its status.
+// Compressed or truncated frames count as UNKNOWN.
+func parseHealthResponse(body []byte) uint64 {
+	if len(body) < 5 || body[0] != 0 {
+		return 0
+	}
+	length := binary.BigEndian.Uint32(body[1:5])
+	if uint64(len(body)-5) < uint64(length) {
+		return 0
+	}
+	return parseHealthStatus(body[5 : 5+length])
+}
+
+// parseHealthStatus reads field 1 (status) of a HealthCheckResponse
+func parseHealthStatus(msg []byte) uint64 {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0
+		}
+		msg = msg[n:]
+
+		switch tag & 0x7 {
+		case 0: // varint
+			value, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0
+			}
+			if tag>>3 == 1 {
+				return value
+			}
+			msg = msg[n:]
+		case 2: // length-delimited
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return 0
+			}
+			msg = msg[n+int(length):]
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return 0
+			}
+			msg = msg[8:]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return 0
+			}
+			msg = msg[4:]
+		default:
+			return 0
+		}
+	}
+	return 0 // UNKNOWN, the default when the field is absent
+}
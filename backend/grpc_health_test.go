@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHealthCheckRequest(t *testing.T) {
+	tests := []struct {
+		service string
+		want    []byte
+	}{
+		{"", []byte{0, 0, 0, 0, 2, 0x0A, 0}},
+		{"echo", []byte{0, 0, 0, 0, 6, 0x0A, 4, 'e', 'c', 'h', 'o'}},
+		// a 200 byte name needs a two byte varint length
+		{strings.Repeat("s", 200), append([]byte{0, 0, 0, 0, 203, 0x0A, 0xC8, 0x01}, strings.Repeat("s", 200)...)},
+	}
+
+	for _, tt := range tests {
+		if got := healthCheckRequest(tt.service); !bytes.Equal(got, tt.want) {
+			t.Errorf("healthCheckRequest(%.10q) = % x, want % x", tt.service, got, tt.want)
+		}
+	}
+}
+
+func TestParseHealthStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want uint64
+	}{
+		{"serving", []byte{0x08, 1}, 1},
+		{"not serving", []byte{0x08, 2}, 2},
+		{"service unknown", []byte{0x08, 3}, 3},
+		{"empty message", nil, 0},
+		{"unknown varint field first", []byte{0x10, 0x96, 0x01, 0x08, 1}, 1},
+		{"unknown string field first", []byte{0x12, 3, 'a', 'b', 'c', 0x08, 1}, 1},
+		{"unknown fixed64 field first", []byte{0x19, 1, 2, 3, 4, 5, 6, 7, 8, 0x08, 1}, 1},
+		{"unknown fixed32 field first", []byte{0x1D, 1, 2, 3, 4, 0x08, 1}, 1},
+		{"truncated varint", []byte{0x08, 0x80}, 0},
+		{"truncated tag", []byte{0x80}, 0},
+		{"string longer than message", []byte{0x12, 10, 'a', 0x08, 1}, 0},
+		{"truncated fixed64", []byte{0x19, 1, 2}, 0},
+		{"group wire type", []byte{0x0B, 0x08, 1}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseHealthStatus(tt.msg); got != tt.want {
+			t.Errorf("%s: parseHealthStatus(% x) = %d, want %d", tt.name, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestParseHealthResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want uint64
+	}{
+		{"serving", []byte{0, 0, 0, 0, 2, 0x08, 1}, 1},
+		{"default status", []byte{0, 0, 0, 0, 0}, 0},
+		{"compressed", []byte{1, 0, 0, 0, 2, 0x08, 1}, 0},
+		{"length past body", []byte{0, 0, 0, 0, 9, 0x08, 1}, 0},
+		{"short prefix", []byte{0, 0, 0}, 0},
+		{"second message ignored", []byte{0, 0, 0, 0, 2, 0x08, 2, 0, 0, 0, 0, 2, 0x08, 1}, 2},
+	}
+
+	for _, tt := range tests {
+		if got := parseHealthResponse(tt.body); got != tt.want {
+			t.Errorf("%s: parseHealthResponse(% x) = %d, want %d", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGRPCHealth(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string // grpc-status
+		trailersOnly bool
+		body         []byte
+		want         bool
+	}{
+		{name: "serving", status: "0", body: []byte{0, 0, 0, 0, 2, 0x08, 1}, want: true},
+		{name: "not serving", status: "0", body: []byte{0, 0, 0, 0, 2, 0x08, 2}},
+		{name: "error status", status: "12", trailersOnly: true},
+		{name: "missing status", body: []byte{0, 0, 0, 0, 2, 0x08, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath, gotType string
+			var gotBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotType = r.URL.Path, r.Header.Get("Content-Type")
+				buf := new(bytes.Buffer)
+				buf.ReadFrom(r.Body)
+				gotBody = buf.Bytes()
+
+				w.Header().Set("Content-Type", "application/grpc")
+				if tt.trailersOnly {
+					w.Header().Set("Grpc-Status", tt.status)
+					return
+				}
+				w.Header().Set("Trailer", "Grpc-Status")
+				w.Write(tt.body)
+				if tt.status != "" {
+					w.Header().Set("Grpc-Status", tt.status)
+				}
+			}))
+			defer server.Close()
+
+			u, _ := url.Parse(server.URL)
+			if got := CheckGRPCHealth(server.Client(), u, "echo"); got != tt.want {
+				t.Errorf("CheckGRPCHealth = %v, want %v", got, tt.want)
+			}
+			if gotPath != "/grpc.health.v1.Health/Check" || gotType != "application/grpc" {
+				t.Errorf("request %s with %s", gotPath, gotType)
+			}
+			if !bytes.Equal(gotBody, healthCheckRequest("echo")) {
+				t.Errorf("request body = % x", gotBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver works out the real client IP of a request. Forwarding headers are
+// only honored when the directly connected peer is a trusted proxy, so clients
+// cannot spoof their address by sending X-Forwarded-For themselves.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver builds a resolver trusting the given CIDRs or plain IPs
+func NewResolver(cidrs []string) (*Resolver, error) {
+	res := &Resolver{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", c)
+			}
+			if ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", c, err)
+		}
+		res.trusted = append(res.trusted, network)
+	}
+	return res, nil
+}
+
+// IsTrusted reports whether ip belongs to one of the trusted proxy ranges
+func (res *Resolver) IsTrusted(ip string) bool {
+	if res == nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range res.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address of r without a port
+func (res *Resolver) ClientIP(r *http.Request) string {
+	peer := StripPort(r.RemoteAddr)
+	if !res.IsTrusted(peer) {
+		return peer
+	}
+
+	// Walk X-Forwarded-For right to left, skipping our own trusted proxies.
+	// The first untrusted hop is the closest address nobody we trust made up.
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) > 0 {
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := StripPort(strings.TrimSpace(hops[i]))
+			if net.ParseIP(hop) == nil {
+				// garbage in the chain, stop at the last hop we could verify
+				return client
+			}
+			client = hop
+			if !res.IsTrusted(hop) {
+				return hop
+			}
+		}
+		return client
+	}
+
+	if realIP := StripPort(strings.TrimSpace(r.Header.Get("X-Real-IP"))); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
+
+// StripPort removes the port (and IPv6 brackets) from an address if present
+func StripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
@@ -0,0 +1,102 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	res, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{"no headers", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"spoofed XFF from untrusted peer", "203.0.113.7:5000", []string{"1.1.1.1"}, "", "203.0.113.7"},
+		{"spoofed X-Real-IP from untrusted peer", "203.0.113.7:5000", nil, "1.1.1.1", "203.0.113.7"},
+		{"single trusted proxy", "10.0.0.1:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"plain trusted IP", "192.168.1.1:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"trusted IP is exact", "192.168.1.2:5000", []string{"198.51.100.1"}, "", "192.168.1.2"},
+		{"multi-hop chain skips trusted hops", "10.0.0.1:5000", []string{"6.6.6.6, 198.51.100.1, 10.0.0.2, 10.0.0.3"}, "", "198.51.100.1"},
+		{"chain split over header lines", "10.0.0.1:5000", []string{"6.6.6.6, 198.51.100.1", "10.0.0.2"}, "", "198.51.100.1"},
+		{"whole chain trusted", "10.0.0.1:5000", []string{"10.0.0.5, 10.0.0.2"}, "", "10.0.0.5"},
+		{"malformed hop stops the walk", "10.0.0.1:5000", []string{"198.51.100.1, not-an-ip, 10.0.0.2"}, "", "10.0.0.2"},
+		{"malformed last hop returns the peer", "10.0.0.1:5000", []string{"198.51.100.1, garbage"}, "", "10.0.0.1"},
+		{"hop with port", "10.0.0.1:5000", []string{"198.51.100.1:4711"}, "", "198.51.100.1"},
+		{"XFF wins over X-Real-IP", "10.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.2", "198.51.100.1"},
+		{"X-Real-IP from trusted peer", "10.0.0.1:5000", nil, "198.51.100.2", "198.51.100.2"},
+		{"malformed X-Real-IP", "10.0.0.1:5000", nil, "nope", "10.0.0.1"},
+		{"IPv6 untrusted peer", "[2606:4700::1]:443", []string{"1.1.1.1"}, "", "2606:4700::1"},
+		{"IPv6 trusted chain", "[2001:db8::1]:443", []string{"2606:4700::6, 2001:db8::2"}, "", "2606:4700::6"},
+		{"IPv6 hop with brackets and port", "[2001:db8::1]:443", []string{"[2606:4700::6]:1234"}, "", "2606:4700::6"},
+		{"remote address without port", "203.0.113.7", nil, "", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := res.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilResolverTrustsNobody(t *testing.T) {
+	var res *Resolver
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+	if got := res.ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("ClientIP = %q, want the peer", got)
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		cidrs   []string
+		wantErr bool
+	}{
+		{[]string{"10.0.0.0/8", " 192.168.0.1 ", ""}, false},
+		{[]string{"::1", "fd00::/8"}, false},
+		{[]string{"not-an-ip"}, true},
+		{[]string{"10.0.0.0/33"}, true},
+		{[]string{"10.0.0.0/8", "300.1.1.1"}, true},
+	}
+	for _, tt := range tests {
+		_, err := NewResolver(tt.cidrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewResolver(%q) error = %v, want error %v", tt.cidrs, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStripPort(t *testing.T) {
+	tests := map[string]string{
+		"1.2.3.4:80":      "1.2.3.4",
+		"1.2.3.4":         "1.2.3.4",
+		"[::1]:80":        "::1",
+		"[2001:db8::1]":   "2001:db8::1",
+		"2001:db8::1":     "2001:db8::1",
+		"example.com:443": "example.com",
+	}
+	for in, want := range tests {
+		if got := StripPort(in); got != want {
+			t.Errorf("StripPort(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
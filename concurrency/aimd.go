@@ -0,0 +1,45 @@
+package concurrency
+
+import (
+	"sync"
+	"time"
+)
+
+// AIMDLimit grows the limit by one while requests succeed and the limit is in
+// use, and cuts it by a fixed factor when a request is dropped or slower than timeout
+type AIMDLimit struct {
+	limit   float64
+	max     int
+	backoff float64
+	timeout time.Duration // 0 only backs off on drops
+	mutex   sync.Mutex
+}
+
+func NewAIMDLimit(initial, max int, timeout time.Duration) *AIMDLimit {
+	return &AIMDLimit{
+		limit:   float64(min(initial, max)),
+		max:     max,
+		backoff: 0.9,
+		timeout: timeout,
+	}
+}
+
+func (a *AIMDLimit) Limit() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return int(a.limit)
+}
+
+func (a *AIMDLimit) OnSample(rtt time.Duration, inflight int, dropped bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if dropped || (a.timeout > 0 && rtt > a.timeout) {
+		a.limit = max(a.limit*a.backoff, 1)
+		return
+	}
+	// Only grow when the limit is actually being used
+	if float64(inflight)*2 >= a.limit {
+		a.limit = min(a.limit+1, float64(a.max))
+	}
+}
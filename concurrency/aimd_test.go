@@ -0,0 +1,60 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAIMDLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial int
+		max     int
+		timeout time.Duration
+		samples []sample
+		want    int
+	}{
+		{"starts at initial", 10, 20, 0, nil, 10},
+		{"initial above max", 30, 20, 0, nil, 20},
+		{"grows while in use", 10, 20, 0, []sample{{10 * time.Millisecond, 5, false}, {10 * time.Millisecond, 6, false}}, 12},
+		{"does not grow while underused", 10, 20, 0, []sample{{10 * time.Millisecond, 4, false}}, 10},
+		{"stops at max", 19, 20, 0, []sample{{0, 19, false}, {0, 20, false}, {0, 20, false}}, 20},
+		{"backs off on drops", 10, 20, 0, []sample{{0, 10, true}}, 9},
+		{"backs off on slow responses", 10, 20, 100 * time.Millisecond, []sample{{200 * time.Millisecond, 10, false}}, 9},
+		{"slow responses are fine without a timeout", 10, 20, 0, []sample{{time.Minute, 10, false}}, 11},
+		{"never below one", 1, 20, 0, []sample{{0, 1, true}, {0, 1, true}}, 1},
+	}
+
+	for _, tt := range tests {
+		a := NewAIMDLimit(tt.initial, tt.max, tt.timeout)
+		for _, s := range tt.samples {
+			a.OnSample(s.rtt, s.inflight, s.dropped)
+		}
+		if got := a.Limit(); got != tt.want {
+			t.Errorf("%s: limit = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAIMDLimitConverges(t *testing.T) {
+	// a backend that answers in 10ms up to 40 requests in flight and slows down past that
+	const capacity = 40
+	a := NewAIMDLimit(5, 100, 50*time.Millisecond)
+
+	lowest, highest := 100, 0
+	for i := 0; i < 2000; i++ {
+		inflight := a.Limit()
+		rtt := 10 * time.Millisecond
+		if inflight > capacity {
+			rtt = 100 * time.Millisecond
+		}
+		a.OnSample(rtt, inflight, false)
+
+		if i >= 1000 { // settled
+			lowest, highest = min(lowest, a.Limit()), max(highest, a.Limit())
+		}
+	}
+	if lowest < capacity*9/10-1 || highest > capacity+1 {
+		t.Errorf("limit moved between %d and %d, want around %d", lowest, highest, capacity)
+	}
+}
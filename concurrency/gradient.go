@@ -0,0 +1,89 @@
+package concurrency
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// GradientLimit follows the ratio between the long term average latency and
+// the latency of recent requests, like Netflix's Gradient2 limit. When requests
+// get slower than usual, queueing is building up at the backend and the limit
+// shrinks; while latency stays flat it grows by about sqrt(limit) per sample.
+type GradientLimit struct {
+	limit     float64
+	max       int
+	shortRTT  float64 // average of the last few samples, in nanoseconds
+	longRTT   float64 // average of the last few hundred samples
+	tolerance float64 // how much slower than usual requests may get before backing off
+	smoothing float64
+	slow      int // consecutive samples slower than tolerated
+	mutex     sync.Mutex
+}
+
+// longWindow is the number of samples the long term average covers
+const longWindow = 600
+
+func NewGradientLimit(initial, max int) *GradientLimit {
+	return &GradientLimit{
+		limit:     float64(min(initial, max)),
+		max:       max,
+		tolerance: 1.5,
+		smoothing: 0.2,
+	}
+}
+
+func (g *GradientLimit) Limit() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return int(g.limit)
+}
+
+func (g *GradientLimit) OnSample(rtt time.Duration, inflight int, dropped bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if dropped {
+		g.limit = max(g.limit*0.9, 1)
+		return
+	}
+
+	sample := float64(rtt)
+	if g.longRTT == 0 {
+		g.shortRTT, g.longRTT = sample, sample
+	}
+	g.shortRTT = ema(g.shortRTT, sample, 10)
+	// Samples slower than tolerated have queued at the backend and must not
+	// become the new normal, or the limit would creep up under sustained
+	// overload. Only when latency stays high for a whole long window, however
+	// far the limit came down, has the backend itself become slower.
+	if sample <= g.tolerance*g.longRTT {
+		g.slow = 0
+	} else {
+		g.slow = min(g.slow+1, longWindow)
+	}
+	if g.slow == 0 || g.slow == longWindow {
+		g.longRTT = ema(g.longRTT, sample, longWindow)
+	}
+
+	// After a long stretch of high latency, let the baseline recover faster
+	if g.longRTT/g.shortRTT > 2 {
+		g.longRTT *= 0.95
+	}
+
+	// Not using half the limit says nothing about how much more the backend can take
+	if float64(inflight) < g.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRTT/g.shortRTT))
+	newLimit := g.limit*gradient + math.Sqrt(g.limit)
+	g.limit = g.limit*(1-g.smoothing) + newLimit*g.smoothing
+	g.limit = math.Max(1, math.Min(g.limit, float64(g.max)))
+}
+
+// ema moves avg towards sample as an average over roughly window samples
+func ema(avg, sample float64, window int) float64 {
+	factor := 2 / float64(window+1)
+	return avg*(1-factor) + sample*factor
+}
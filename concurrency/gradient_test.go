@@ -0,0 +1,72 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGradientLimit(t *testing.T) {
+	flat := func(n, inflight int) []sample {
+		samples := make([]sample, n)
+		for i := range samples {
+			samples[i] = sample{rtt: 10 * time.Millisecond, inflight: inflight}
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name    string
+		max     int
+		samples []sample
+		atLeast int
+		atMost  int
+	}{
+		{"starts at initial", 100, nil, 20, 20},
+		{"grows with flat latency", 100, flat(10, 20), 25, 100},
+		{"reaches max with flat latency", 100, flat(200, 100), 100, 100},
+		{"does not grow while underused", 100, flat(50, 5), 20, 20},
+		{"backs off on drops", 100, []sample{{0, 20, true}}, 18, 18},
+		// the max keeps the limit at 20 while the baseline settles
+		{"shrinks when latency jumps", 20, append(flat(100, 20), sample{rtt: time.Second, inflight: 20}, sample{rtt: time.Second, inflight: 20}), 1, 19},
+	}
+
+	for _, tt := range tests {
+		g := NewGradientLimit(20, tt.max)
+		for _, s := range tt.samples {
+			g.OnSample(s.rtt, s.inflight, s.dropped)
+		}
+		if got := g.Limit(); got < tt.atLeast || got > tt.atMost {
+			t.Errorf("%s: limit = %d, want between %d and %d", tt.name, got, tt.atLeast, tt.atMost)
+		}
+	}
+}
+
+func TestGradientLimitConverges(t *testing.T) {
+	// a backend with 40 workers, queueing requests beyond that
+	const capacity = 40
+	g := NewGradientLimit(5, 200)
+	serviceTime := 10 * time.Millisecond
+
+	run := func(samples int) (lowest, highest int) {
+		lowest, highest = 200, 0
+		for i := 0; i < samples; i++ {
+			inflight := g.Limit()
+			g.OnSample(serviceTime*time.Duration((inflight+capacity-1)/capacity), inflight, false)
+			if i >= samples/2 { // settled
+				lowest, highest = min(lowest, g.Limit()), max(highest, g.Limit())
+			}
+		}
+		return lowest, highest
+	}
+
+	// queueing must not creep into the baseline and lift the limit over time
+	if lowest, highest := run(5000); lowest < capacity*3/4 || highest > capacity*5/4 {
+		t.Errorf("limit moved between %d and %d, want around %d", lowest, highest, capacity)
+	}
+
+	// a backend that got slower at any load is the new normal once latency stays up
+	serviceTime = 50 * time.Millisecond
+	if lowest, highest := run(5000); lowest < capacity*3/4 || highest > capacity*5/4 {
+		t.Errorf("after the backend slowed down the limit moved between %d and %d, want around %d", lowest, highest, capacity)
+	}
+}
@@ -0,0 +1,108 @@
+package concurrency
+
+import (
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// Limit decides how many requests may be in flight at once
+type Limit interface {
+	Limit() int
+	// OnSample reports a finished request: how long the backend took to respond,
+	// how many requests were in flight when it started and whether it was dropped
+	OnSample(rtt time.Duration, inflight int, dropped bool)
+}
+
+// FixedLimit always allows the same number of requests in flight
+type FixedLimit int
+
+func (f FixedLimit) Limit() int { return int(f) }
+
+func (f FixedLimit) OnSample(rtt time.Duration, inflight int, dropped bool) {}
+
+// Limiter counts requests in flight and rejects new ones once its limit is reached.
+// A nil Limiter allows everything, so callers do not need to check if one is configured.
+type Limiter struct {
+	limit    Limit
+	inflight int
+	clock    clock.Clock // measures request latency
+	mutex    sync.Mutex
+}
+
+// NewLimiter creates a limiter timing requests with clk, nil uses the system clock
+func NewLimiter(limit Limit, clk clock.Clock) *Limiter {
+	return &Limiter{limit: limit, clock: clock.OrReal(clk)}
+}
+
+// Slot is one admitted request. Release must be called when it finishes.
+type Slot struct {
+	limiter   *Limiter
+	inflight  int
+	start     time.Time
+	responded time.Duration
+	dropped   bool
+}
+
+// Acquire admits a request if fewer than the limit are in flight
+func (l *Limiter) Acquire() (*Slot, bool) {
+	if l == nil {
+		return nil, true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.inflight >= l.limit.Limit() {
+		return nil, false
+	}
+	l.inflight++
+	return &Slot{limiter: l, inflight: l.inflight, start: l.clock.Now()}, true
+}
+
+// InFlight returns the number of admitted requests that are not released yet
+func (l *Limiter) InFlight() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inflight
+}
+
+// Limit returns the current limit
+func (l *Limiter) Limit() int {
+	return l.limit.Limit()
+}
+
+// Responded records the time the backend took to answer, so streaming the
+// body afterwards does not count as latency
+func (s *Slot) Responded() {
+	if s != nil && s.responded == 0 {
+		s.responded = s.limiter.clock.Now().Sub(s.start)
+	}
+}
+
+// Drop marks the request as failed because of overload, e.g. a backend error or 503
+func (s *Slot) Drop() {
+	if s != nil {
+		s.dropped = true
+	}
+}
+
+// Release frees the slot and feeds the request's latency to the limit
+func (s *Slot) Release() {
+	if s == nil {
+		return
+	}
+
+	rtt := s.responded
+	if rtt == 0 {
+		rtt = s.limiter.clock.Now().Sub(s.start)
+	}
+
+	l := s.limiter
+	l.mutex.Lock()
+	l.inflight--
+	l.mutex.Unlock()
+
+	l.limit.OnSample(rtt, s.inflight, s.dropped)
+}
@@ -0,0 +1,89 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// sample is one call of Limit.OnSample
+type sample struct {
+	rtt      time.Duration
+	inflight int
+	dropped  bool
+}
+
+// recordingLimit is a fixed limit that remembers its samples
+type recordingLimit struct {
+	FixedLimit
+	samples []sample
+}
+
+func (r *recordingLimit) OnSample(rtt time.Duration, inflight int, dropped bool) {
+	r.samples = append(r.samples, sample{rtt, inflight, dropped})
+}
+
+func TestLimiterAcquire(t *testing.T) {
+	l := NewLimiter(FixedLimit(2), nil)
+
+	a, ok1 := l.Acquire()
+	b, ok2 := l.Acquire()
+	_, ok3 := l.Acquire()
+	if !ok1 || !ok2 || ok3 {
+		t.Fatalf("admitted %v, %v, %v, want the first two", ok1, ok2, ok3)
+	}
+	if l.InFlight() != 2 {
+		t.Errorf("InFlight = %d, want 2", l.InFlight())
+	}
+
+	a.Release()
+	if _, ok := l.Acquire(); !ok {
+		t.Error("released slot was not reused")
+	}
+	b.Release()
+	if l.InFlight() != 1 {
+		t.Errorf("InFlight = %d, want 1", l.InFlight())
+	}
+
+	var none *Limiter
+	if slot, ok := none.Acquire(); !ok {
+		t.Error("nil limiter rejected a request")
+	} else {
+		slot.Responded()
+		slot.Drop()
+		slot.Release()
+	}
+}
+
+func TestSlotSamples(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limit := &recordingLimit{FixedLimit: 10}
+	l := NewLimiter(limit, clk)
+
+	// latency stops at the response headers, streaming the body does not count
+	first, _ := l.Acquire()
+	clk.Advance(30 * time.Millisecond)
+	first.Responded()
+	second, _ := l.Acquire()
+	clk.Advance(time.Second)
+	first.Release()
+
+	// without a response the whole request counts
+	clk.Advance(20 * time.Millisecond)
+	second.Drop()
+	second.Release()
+
+	want := []sample{
+		{rtt: 30 * time.Millisecond, inflight: 1},
+		{rtt: 1020 * time.Millisecond, inflight: 2, dropped: true},
+	}
+	if len(limit.samples) != len(want) {
+		t.Fatalf("samples = %+v, want %+v", limit.samples, want)
+	}
+	for i := range want {
+		if limit.samples[i] != want[i] {
+			t.Errorf("sample %d = %+v, want %+v", i, limit.samples[i], want[i])
+		}
+	}
+}
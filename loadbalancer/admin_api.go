@@ -0,0 +1,339 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang-load-balancer/backend"
+	"golang-load-balancer/ratelimiter"
+)
+
+// adminPrefix is the root of the versioned admin API
+const adminPrefix = "/admin/v1"
+
+// defaultPool names the pool of backends given on the command line
+const defaultPool = "default"
+
+// adminAPI serves the JSON admin API. Backends are addressed by the host:port
+// of their URL within a pool, e.g. /admin/v1/pools/default/backends/localhost:8081.
+type adminAPI struct {
+	pools  map[string]*ServerPool
+	limits *concurrencyLimits
+	mux    *http.ServeMux
+}
+
+func newAdminAPI(pools map[string]*ServerPool, limits *concurrencyLimits) *adminAPI {
+	a := &adminAPI{pools: pools, limits: limits, mux: http.NewServeMux()}
+	a.mux.HandleFunc(adminPrefix+"/pools", a.listPools)
+	a.mux.HandleFunc(adminPrefix+"/pools/{pool}", a.getPool)
+	a.mux.HandleFunc(adminPrefix+"/pools/{pool}/backends", a.poolBackends)
+	a.mux.HandleFunc(adminPrefix+"/pools/{pool}/backends/{id}", a.backend)
+	a.mux.HandleFunc(adminPrefix+"/pools/{pool}/backends/{id}/{action}", a.backendAction)
+	a.mux.HandleFunc(adminPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "no admin endpoint at %s", r.URL.Path)
+	})
+	return a
+}
+
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+type poolView struct {
+	Name     string        `json:"name"`
+	Strategy string        `json:"strategy"`
+	Healthy  int           `json:"healthy"` // backends taking traffic
+	Queued   int           `json:"queued"`  // requests waiting for a backend
+	Backends []backendView `json:"backends"`
+}
+
+type backendView struct {
+	ID                string       `json:"id"`
+	URL               string       `json:"url"`
+	Healthy           bool         `json:"healthy"` // last health check passed
+	Enabled           bool         `json:"enabled"`
+	Draining          bool         `json:"draining"`
+	Weight            int          `json:"weight"`
+	Tags              []string     `json:"tags"`
+	ActiveConnections int          `json:"active_connections"`
+	Stats             backendStats `json:"stats"`
+}
+
+type backendStats struct {
+	Requests      int64 `json:"requests"`
+	Failures      int64 `json:"failures"` // 5xx responses and failed connections
+	InFlight      int   `json:"in_flight,omitempty"`
+	InFlightLimit int   `json:"in_flight_limit,omitempty"` // only with -max-inflight-per-backend
+}
+
+func (a *adminAPI) viewPool(name string, pool *ServerPool) poolView {
+	view := poolView{
+		Name:     name,
+		Strategy: string(pool.GetStrategyType()),
+		Queued:   pool.waiters.Len(),
+		Backends: []backendView{},
+	}
+	for _, b := range pool.snapshot() {
+		if b.IsAlive() {
+			view.Healthy++
+		}
+		view.Backends = append(view.Backends, a.viewBackend(pool, b))
+	}
+	return view
+}
+
+func (a *adminAPI) viewBackend(pool *ServerPool, b *backend.Backend) backendView {
+	requests, failures := b.Stats()
+	view := backendView{
+		ID:                backendID(b),
+		URL:               b.URL.String(),
+		Healthy:           b.IsHealthy(),
+		Enabled:           !b.IsDisabled(),
+		Draining:          b.IsDraining(),
+		Weight:            pool.Weight(b),
+		Tags:              b.GetTags(),
+		ActiveConnections: b.GetConnections(),
+		Stats:             backendStats{Requests: requests, Failures: failures},
+	}
+	if l := a.limits.forBackend(b); l != nil {
+		view.Stats.InFlight = l.InFlight()
+		view.Stats.InFlightLimit = l.Limit()
+	}
+	return view
+}
+
+func backendID(b *backend.Backend) string {
+	return b.URL.Host
+}
+
+// GET /admin/v1/pools
+func (a *adminAPI) listPools(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	pools := []poolView{}
+	for _, name := range slices.Sorted(maps.Keys(a.pools)) {
+		pools = append(pools, a.viewPool(name, a.pools[name]))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"pools": pools})
+}
+
+// GET /admin/v1/pools/{pool}
+func (a *adminAPI) getPool(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	pool, ok := a.findPool(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.viewPool(r.PathValue("pool"), pool))
+}
+
+// GET and POST /admin/v1/pools/{pool}/backends
+func (a *adminAPI) poolBackends(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	pool, ok := a.findPool(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, map[string]any{"backends": a.viewPool(r.PathValue("pool"), pool).Backends})
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url"`
+		Weight *int     `json:"weight"`
+		Tags   []string `json:"tags"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeAPIError(w, http.StatusBadRequest, "url must be an absolute http or https URL, e.g. http://localhost:8081")
+		return
+	}
+	weight := 1
+	if req.Weight != nil {
+		weight = *req.Weight
+	}
+	if weight < 1 {
+		writeAPIError(w, http.StatusBadRequest, "weight must be at least 1")
+		return
+	}
+	b, err := pool.AddBackendDynamic(u.String(), weight)
+	if errors.Is(err, errBackendExists) {
+		writeAPIError(w, http.StatusConflict, "backend %s already exists", u.Host)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	b.SetTags(req.Tags)
+	w.Header().Set("Location", r.URL.Path+"/"+backendID(b))
+	writeJSON(w, http.StatusCreated, a.viewBackend(pool, b))
+}
+
+// GET, PATCH and DELETE /admin/v1/pools/{pool}/backends/{id}
+func (a *adminAPI) backend(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+	pool, b, ok := a.findBackend(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var req struct {
+			Weight *int      `json:"weight"`
+			Tags   *[]string `json:"tags"`
+		}
+		if !readJSON(w, r, &req) {
+			return
+		}
+		if req.Weight != nil && *req.Weight < 1 {
+			writeAPIError(w, http.StatusBadRequest, "weight must be at least 1")
+			return
+		}
+		if req.Weight != nil {
+			pool.SetWeight(b, *req.Weight)
+		}
+		if req.Tags != nil {
+			b.SetTags(*req.Tags)
+		}
+		log.Printf("Updated backend %s", b.URL.String())
+	case http.MethodDelete:
+		if err := pool.RemoveBackendDynamic(b.URL.String()); err != nil {
+			writeAPIError(w, http.StatusNotFound, "%v", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.viewBackend(pool, b))
+}
+
+// POST /admin/v1/pools/{pool}/backends/{id}/{drain,enable,disable}
+func (a *adminAPI) backendAction(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+	if action != "drain" && action != "enable" && action != "disable" {
+		writeAPIError(w, http.StatusNotFound, "unknown backend action %q, use drain, enable or disable", action)
+		return
+	}
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	pool, b, ok := a.findBackend(w, r)
+	if !ok {
+		return
+	}
+
+	switch action {
+	case "drain":
+		var req struct {
+			Grace *ratelimiter.Duration `json:"grace"` // 30s if empty
+		}
+		if r.ContentLength != 0 && !readJSON(w, r, &req) {
+			return
+		}
+		grace := 30 * time.Second
+		if req.Grace != nil {
+			grace = time.Duration(*req.Grace)
+		}
+		if grace < 0 {
+			writeAPIError(w, http.StatusBadRequest, "grace must not be negative")
+			return
+		}
+		pool.DrainBackend(b, grace)
+	case "enable":
+		b.SetDisabled(false)
+		b.SetDraining(false)
+		pool.notifyCapacity()
+		log.Printf("Enabled backend %s", b.URL.String())
+	case "disable":
+		b.SetDisabled(true)
+		log.Printf("Disabled backend %s", b.URL.String())
+	}
+	writeJSON(w, http.StatusOK, a.viewBackend(pool, b))
+}
+
+func (a *adminAPI) findPool(w http.ResponseWriter, r *http.Request) (*ServerPool, bool) {
+	name := r.PathValue("pool")
+	pool, ok := a.pools[name]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "pool %s not found", name)
+	}
+	return pool, ok
+}
+
+func (a *adminAPI) findBackend(w http.ResponseWriter, r *http.Request) (*ServerPool, *backend.Backend, bool) {
+	pool, ok := a.findPool(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	id := r.PathValue("id")
+	for _, b := range pool.snapshot() {
+		if backendID(b) == id {
+			return pool, b, true
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, "backend %s not found in pool %s", id, r.PathValue("pool"))
+	return nil, nil, false
+}
+
+// allowMethods answers 405 unless the request uses one of methods
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "method %s not allowed, use %s", r.Method, strings.Join(methods, ", "))
+	return false
+}
+
+// readJSON decodes a request body of at most 1MB, rejecting unknown fields
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("request body is empty")
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: %v", err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError answers with {"error": {"code": "not_found", "message": "..."}},
+// the code being the snake_case status text
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...any) {
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	writeJSON(w, status, map[string]any{
+		"error": map[string]string{"code": code, "message": fmt.Sprintf(format, args...)},
+	})
+}
@@ -0,0 +1,98 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	pool := NewServerPool("")
+	pool.AddBackendDynamic("http://localhost:8081", 1)
+	limits, _ := newConcurrencyLimits(ConcurrencyConfig{}, nil)
+	api := newAdminAPI(map[string]*ServerPool{defaultPool: pool}, limits)
+
+	backendPath := adminPrefix + "/pools/default/backends/localhost:8082"
+	steps := []struct {
+		method, path, body string
+		status             int
+		want               string // substring of the response body
+	}{
+		{"GET", adminPrefix + "/pools", "", http.StatusOK, `"id":"localhost:8081"`},
+		{"GET", adminPrefix + "/pools/other", "", http.StatusNotFound, `"code":"not_found"`},
+		{"POST", adminPrefix + "/pools/default/backends", `{"url":"http://localhost:8082","weight":2,"tags":["canary"]}`, http.StatusCreated, `"tags":["canary"]`},
+		{"POST", adminPrefix + "/pools/default/backends", `{"url":"http://localhost:8082"}`, http.StatusConflict, `"code":"conflict"`},
+		{"POST", adminPrefix + "/pools/default/backends", `{"url":"localhost"}`, http.StatusBadRequest, `"code":"bad_request"`},
+		{"POST", adminPrefix + "/pools/default/backends", `{"url":"file:///etc/passwd"}`, http.StatusBadRequest, "http or https"},
+		{"POST", adminPrefix + "/pools/default/backends", `{"url":"tcp://localhost:5432"}`, http.StatusBadRequest, "http or https"},
+		{"POST", adminPrefix + "/pools/default/backends", `{"url":"http://localhost:8083","weight":0}`, http.StatusBadRequest, "weight"},
+		{"GET", backendPath, "", http.StatusOK, `"weight":2`},
+		{"PATCH", backendPath, `{"weight":5}`, http.StatusOK, `"weight":5,"tags":["canary"]`},
+		{"PATCH", backendPath, `{"tags":[]}`, http.StatusOK, `"tags":[]`},
+		{"PATCH", backendPath, `{"color":"red"}`, http.StatusBadRequest, "unknown field"},
+		{"PUT", backendPath, "", http.StatusMethodNotAllowed, `"code":"method_not_allowed"`},
+		{"POST", backendPath + "/disable", "", http.StatusOK, `"enabled":false`},
+		{"POST", backendPath + "/enable", "", http.StatusOK, `"enabled":true`},
+		{"POST", backendPath + "/drain", `{"grace":"0s"}`, http.StatusOK, `"draining":true`},
+		{"POST", backendPath + "/enable", "", http.StatusOK, `"draining":false`},
+		{"POST", backendPath + "/restart", "", http.StatusNotFound, "unknown backend action"},
+		{"DELETE", backendPath, "", http.StatusNoContent, ""},
+		{"GET", backendPath, "", http.StatusNotFound, "backend localhost:8082 not found"},
+		{"GET", adminPrefix + "/nothing", "", http.StatusNotFound, `"code":"not_found"`},
+	}
+
+	for _, s := range steps {
+		req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.body))
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+
+		if rec.Code != s.status {
+			t.Fatalf("%s %s: status %d, want %d: %s", s.method, s.path, rec.Code, s.status, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), s.want) {
+			t.Errorf("%s %s: body %s does not contain %s", s.method, s.path, rec.Body, s.want)
+		}
+		if rec.Code != http.StatusNoContent && !json.Valid(rec.Body.Bytes()) {
+			t.Errorf("%s %s: body is not JSON: %s", s.method, s.path, rec.Body)
+		}
+	}
+}
+
+func TestAdminAPIConcurrentAdds(t *testing.T) {
+	pool := NewServerPool("")
+	limits, _ := newConcurrencyLimits(ConcurrencyConfig{}, nil)
+	api := newAdminAPI(map[string]*ServerPool{defaultPool: pool}, limits)
+
+	const callers = 20
+	statuses := make(chan int, callers)
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", adminPrefix+"/pools/default/backends", strings.NewReader(`{"url":"http://localhost:8082"}`))
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+			statuses <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status %d, want %d or %d", status, http.StatusCreated, http.StatusConflict)
+		}
+	}
+	if created != 1 || len(pool.GetBackends()) != 1 {
+		t.Errorf("%d adds succeeded and the pool has %d backends, want 1 and 1", created, len(pool.GetBackends()))
+	}
+}
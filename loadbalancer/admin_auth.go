@@ -0,0 +1,222 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// AdminRole decides what a caller of the admin API may do
+type AdminRole string
+
+const (
+	RoleRead  AdminRole = "read"  // GET requests only
+	RoleWrite AdminRole = "write" // everything, including adding and removing backends
+)
+
+// AdminAuth lists who may call the admin API: callers presenting a bearer
+// token, and clients whose verified certificate has one of the common names
+type AdminAuth struct {
+	Tokens []struct {
+		Name  string    `json:"name"`
+		Token string    `json:"token"`
+		Role  AdminRole `json:"role"`
+	} `json:"tokens"`
+	Clients []struct {
+		Name string    `json:"name"` // certificate common name
+		Role AdminRole `json:"role"`
+	} `json:"clients"`
+}
+
+// LoadAdminAuth reads admin credentials from a JSON file like
+// {"tokens": [{"name": "deploy", "token": "...", "role": "write"}], "clients": [{"name": "ops.example.com", "role": "read"}]}
+func LoadAdminAuth(path string) (*AdminAuth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &AdminAuth{}
+	if err := json.Unmarshal(data, auth); err != nil {
+		return nil, fmt.Errorf("parsing admin credentials %s: %v", path, err)
+	}
+	for _, t := range auth.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("admin token %q is empty", t.Name)
+		}
+		if err := t.Role.validate(); err != nil {
+			return nil, fmt.Errorf("admin token %q: %v", t.Name, err)
+		}
+	}
+	for _, c := range auth.Clients {
+		if err := c.Role.validate(); err != nil {
+			return nil, fmt.Errorf("admin client %q: %v", c.Name, err)
+		}
+	}
+	return auth, nil
+}
+
+func (role AdminRole) validate() error {
+	if role != RoleRead && role != RoleWrite {
+		return fmt.Errorf("unknown role %q, use read or write", role)
+	}
+	return nil
+}
+
+// authenticate finds the caller by client certificate, then by bearer token
+func (auth *AdminAuth) authenticate(r *http.Request) (name string, role AdminRole, ok bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, c := range auth.Clients {
+			if c.Name == cn {
+				return "cert:" + c.Name, c.Role, true
+			}
+		}
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", "", false
+	}
+	for _, t := range auth.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return "token:" + t.Name, t.Role, true
+		}
+	}
+	return "", "", false
+}
+
+// isMutating reports whether a request changes state and needs the write role
+func isMutating(r *http.Request) bool {
+	return r.Method != http.MethodGet && r.Method != http.MethodHead
+}
+
+// anonymousRole is the role of every caller when the admin API on addr runs
+// without credentials. Any local user can reach a loopback port, so only the
+// owner-only unix socket lets them change anything.
+func anonymousRole(addr string) AdminRole {
+	if strings.HasPrefix(addr, "unix:") {
+		return RoleWrite
+	}
+	return RoleRead
+}
+
+// requireAdminRole rejects callers auth does not know, and read-only callers
+// of mutating endpoints. Without auth every caller has the anonymous role,
+// startAdmin only allows that on loopback addresses and unix sockets.
+func requireAdminRole(auth *AdminAuth, anonymous AdminRole, audit *auditLog, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, role, ok := "anonymous", anonymous, true
+		if auth != nil {
+			name, role, ok = auth.authenticate(r)
+		}
+
+		if isMutating(r) {
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			entry := audit.start(r, name, role)
+			defer func() { audit.finish(entry, sw.status) }()
+			w = sw
+		}
+
+		switch {
+		case !ok:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeAPIError(w, http.StatusUnauthorized, "a valid bearer token or client certificate is required")
+		case isMutating(r) && role != RoleWrite && auth == nil:
+			writeAPIError(w, http.StatusForbidden, "the admin API is read-only without -admin-auth, unless it listens on a unix socket")
+		case isMutating(r) && role != RoleWrite:
+			writeAPIError(w, http.StatusForbidden, "%s has role %s and may not call %s %s", name, role, r.Method, r.URL.Path)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// statusWriter remembers the status code sent to the client
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// auditLog records every mutating admin call as one JSON line
+type auditLog struct {
+	w     io.Writer // the standard log if nil
+	clock clock.Clock
+	mutex sync.Mutex
+}
+
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Caller   string    `json:"caller"`
+	Role     AdminRole `json:"role,omitempty"`
+	Remote   string    `json:"remote"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Body     string    `json:"body,omitempty"`
+	Status   int       `json:"status"`
+	Duration string    `json:"duration"`
+}
+
+// maxAuditBody is the most of a request body copied into the audit log
+const maxAuditBody = 4 << 10
+
+// start captures the request before the handler consumes its body
+func (a *auditLog) start(r *http.Request, caller string, role AdminRole) *auditEntry {
+	entry := &auditEntry{
+		Time:   a.clock.Now(),
+		Caller: caller,
+		Role:   role,
+		Remote: r.RemoteAddr,
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+	}
+	if caller == "" {
+		entry.Caller = "unauthenticated"
+	}
+
+	if r.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditBody))
+		entry.Body = string(body)
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	}
+	return entry
+}
+
+func (a *auditLog) finish(entry *auditEntry, status int) {
+	entry.Status = status
+	entry.Duration = a.clock.Now().Sub(entry.Time).String()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.w == nil {
+		log.Printf("Admin audit: %s", line)
+		return
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("Writing admin audit log failed: %v", err)
+	}
+}
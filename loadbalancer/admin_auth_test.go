@@ -0,0 +1,274 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang-load-balancer/clock"
+)
+
+func TestAdminRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	os.WriteFile(path, []byte(`{"tokens": [
+		{"name": "deploy", "token": "w-secret", "role": "write"},
+		{"name": "grafana", "token": "r-secret", "role": "read"}
+	]}`), 0o600)
+	auth, err := LoadAdminAuth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var audit bytes.Buffer
+	handler := requireAdminRole(auth, RoleRead, &auditLog{w: &audit, clock: clock.Real}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		method, token string
+		status        int
+	}{
+		{"GET", "", http.StatusUnauthorized},
+		{"GET", "wrong", http.StatusUnauthorized},
+		{"GET", "r-secret", http.StatusNoContent},
+		{"POST", "r-secret", http.StatusForbidden},
+		{"DELETE", "w-secret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/admin/v1/pools", strings.NewReader(`{"weight": 2}`))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s with token %q: status %d, want %d", tt.method, tt.token, rec.Code, tt.status)
+		}
+	}
+
+	// only the two mutating calls are audited, including the forbidden one
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d entries, want 2:\n%s", len(lines), audit.String())
+	}
+	var entry auditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Caller != "token:grafana" || entry.Method != "POST" || entry.Status != http.StatusForbidden || entry.Body != `{"weight": 2}` {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+}
+
+func TestAdminWithoutAuth(t *testing.T) {
+	for addr, mutable := range map[string]bool{
+		"localhost:8091":    false,
+		"127.0.0.1:8091":    false,
+		"unix:/run/lb.sock": true,
+	} {
+		handler := requireAdminRole(nil, anonymousRole(addr), &auditLog{w: &bytes.Buffer{}, clock: clock.Real}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		for _, method := range []string{"GET", "POST", "DELETE"} {
+			want := http.StatusNoContent
+			if method != "GET" && !mutable {
+				want = http.StatusForbidden
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(method, "/admin/v1/pools", nil))
+			if rec.Code != want {
+				t.Errorf("%s on %s without auth: status %d, want %d", method, addr, rec.Code, want)
+			}
+		}
+	}
+}
+
+func TestLoadAdminAuthRejectsUnknownRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	os.WriteFile(path, []byte(`{"tokens": [{"name": "x", "token": "t", "role": "root"}]}`), 0o600)
+	if _, err := LoadAdminAuth(path); err == nil {
+		t.Error("role root was accepted")
+	}
+}
+
+func TestIsLocalAddr(t *testing.T) {
+	tests := map[string]bool{
+		"localhost:8091":    true,
+		"127.0.0.1:8091":    true,
+		"[::1]:8091":        true,
+		"unix:/run/lb.sock": true,
+		":8091":             false,
+		"0.0.0.0:8091":      false,
+		"10.0.0.5:8091":     false,
+	}
+	for addr, want := range tests {
+		if got := isLocalAddr(addr); got != want {
+			t.Errorf("isLocalAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestAdminClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := newTestCert(t, dir, "server-ca", "server ca", nil, nil, true)
+	server := newTestCert(t, dir, "server", "", []string{"localhost"}, serverCA, false)
+	clientCA := newTestCert(t, dir, "client-ca", "client ca", nil, nil, true)
+	ops := newTestCert(t, dir, "ops", "ops.example.com", nil, clientCA, false)
+	stranger := newTestCert(t, dir, "stranger", "stranger.example.com", nil, clientCA, false)
+
+	path := filepath.Join(dir, "auth.json")
+	os.WriteFile(path, []byte(`{
+		"tokens": [{"name": "deploy", "token": "w-secret", "role": "write"}],
+		"clients": [{"name": "ops.example.com", "role": "read"}]
+	}`), 0o600)
+	auth, err := LoadAdminAuth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var audit lockedBuffer
+	socket := filepath.Join(dir, "admin.sock")
+	_, err = startAdmin(AdminConfig{
+		Addr:     "unix:" + socket,
+		TLS:      &TLSConfig{Certificates: []CertificateFiles{server.files()}, ClientAuth: "request", ClientCAFile: clientCA.certFile},
+		Auth:     auth,
+		AuditLog: &audit,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	call := func(method, token string, cert *testCert) int {
+		t.Helper()
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if cert != nil {
+			pair, err := tls.LoadX509KeyPair(cert.certFile, cert.keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+			TLSClientConfig: tlsConfig,
+		}}
+		req, _ := http.NewRequest(method, "https://localhost/admin/v1/pools", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name          string
+		method, token string
+		cert          *testCert
+		status        int
+	}{
+		{"known common name reads", "GET", "", ops, http.StatusNoContent},
+		{"known common name keeps its role", "POST", "", ops, http.StatusForbidden},
+		{"certificate beats the token", "POST", "w-secret", ops, http.StatusForbidden},
+		{"unknown common name without token", "GET", "", stranger, http.StatusUnauthorized},
+		{"unknown common name falls back to the token", "POST", "w-secret", stranger, http.StatusNoContent},
+		{"token without certificate", "DELETE", "w-secret", nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if status := call(tt.method, tt.token, tt.cert); status != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.status)
+		}
+	}
+
+	callers := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		callers = append(callers, entry.Caller)
+	}
+	want := []string{"cert:ops.example.com", "cert:ops.example.com", "token:deploy", "token:deploy"}
+	if strings.Join(callers, ",") != strings.Join(want, ",") {
+		t.Errorf("audited callers = %v, want %v", callers, want)
+	}
+}
+
+func TestAdminUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	// a stale socket from an earlier run is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenAdmin("unix:" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://lb/admin/v1/pools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status %d over the socket, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+}
+
+// lockedBuffer is an audit log written by server goroutines and read by the test
+type lockedBuffer struct {
+	buf   bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
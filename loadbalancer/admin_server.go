@@ -0,0 +1,99 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang-load-balancer/clock"
+)
+
+// AdminConfig describes the separate listener of the admin API
+type AdminConfig struct {
+	Addr     string     // host:port or unix:/path/to/socket, the admin API is disabled if empty
+	TLS      *TLSConfig // serve HTTPS, optionally verifying client certificates; its Addr is ignored
+	Auth     *AdminAuth // who may call the API, nil allows reads on loopback addresses and everything on unix sockets
+	AuditLog io.Writer  // mutating calls as JSON lines, the standard log if nil
+}
+
+// listenAdmin opens a TCP or unix socket listener. A stale socket file left by
+// an earlier run is replaced, and the new one is only accessible to our user.
+func listenAdmin(addr string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// isLocalAddr reports whether only this machine can reach addr
+func isLocalAddr(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startAdmin serves handler on the admin listener in the background
+func startAdmin(cfg AdminConfig, handler http.Handler, clk clock.Clock) (*http.Server, error) {
+	if cfg.Auth == nil && !isLocalAddr(cfg.Addr) {
+		return nil, fmt.Errorf("admin API on %s needs credentials, or listen on localhost or a unix socket", cfg.Addr)
+	}
+
+	audit := &auditLog{w: cfg.AuditLog, clock: clock.OrReal(clk)}
+	handler = requireAdminRole(cfg.Auth, anonymousRole(cfg.Addr), audit, handler)
+
+	listener, err := listenAdmin(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TLS != nil {
+		server, err := newTLSServer(cfg.TLS, handler, clk)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		go func() {
+			log.Printf("Starting admin API (TLS) on %s", cfg.Addr)
+			if err := server.ServeTLS(listener, "", ""); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+		return server, nil
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		log.Printf("Starting admin API on %s", cfg.Addr)
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	return server, nil
+}
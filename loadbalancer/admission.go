@@ -0,0 +1,111 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"golang-load-balancer/backend"
+	"golang-load-balancer/concurrency"
+)
+
+var (
+	errOverloaded = errors.New("too many requests in flight")
+	errNoBackend  = errors.New("no healthy backends available")
+)
+
+// admission is a request that got a backend and a slot in every concurrency limit
+type admission struct {
+	pool        *ServerPool
+	backend     *backend.Backend
+	globalSlot  *concurrency.Slot
+	backendSlot *concurrency.Slot
+}
+
+// admitRequest picks a backend for the request if the concurrency limits have
+// room. When the strategy's pick is full the other backends are tried, so the
+// request is only shed once all of them are; keyed strategies keep the client
+// on its own backend instead.
+func admitRequest(pool *ServerPool, limits *concurrencyLimits, clientIP string) (*admission, error) {
+	globalSlot, ok := limits.global.Acquire()
+	if !ok {
+		return nil, errOverloaded
+	}
+
+	b := pool.GetNextBackendFor(clientIP)
+	if b == nil {
+		globalSlot.Release()
+		return nil, errNoBackend
+	}
+
+	backendSlot, ok := limits.forBackend(b).Acquire()
+	if !ok {
+		pool.releaseBackend(b)
+		if pool.keyed() {
+			globalSlot.Release()
+			return nil, errOverloaded
+		}
+		if b, backendSlot = otherBackendWithRoom(pool, limits, b); b == nil {
+			globalSlot.Release()
+			return nil, errOverloaded
+		}
+	}
+	return &admission{pool: pool, backend: b, globalSlot: globalSlot, backendSlot: backendSlot}, nil
+}
+
+// otherBackendWithRoom takes a slot on the first alive backend after full, in
+// pool order so that retries spread over the pool, and counts the connection
+func otherBackendWithRoom(pool *ServerPool, limits *concurrencyLimits, full *backend.Backend) (*backend.Backend, *concurrency.Slot) {
+	for _, b := range pool.others(full) {
+		if !b.IsAlive() {
+			continue
+		}
+		if slot, ok := limits.forBackend(b).Acquire(); ok {
+			return acquire(b), slot
+		}
+	}
+	return nil, nil
+}
+
+// responded feeds the time to the backend's response to the adaptive limits
+func (a *admission) responded(status int) {
+	a.backend.RecordRequest(status >= http.StatusInternalServerError)
+	a.globalSlot.Responded()
+	a.backendSlot.Responded()
+	if isOverloaded(status) {
+		a.drop()
+	}
+}
+
+// drop marks the request as failed because the backend is struggling
+func (a *admission) drop() {
+	a.globalSlot.Drop()
+	a.backendSlot.Drop()
+}
+
+// release frees everything the request held and lets a queued request take its place
+func (a *admission) release() {
+	a.backendSlot.Release()
+	a.pool.releaseBackend(a.backend)
+	a.globalSlot.Release()
+	a.pool.notifyCapacity()
+}
+
+// rejectRequest answers a request that could not be admitted
+func rejectRequest(w http.ResponseWriter, grpc bool, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return // the client is gone
+	case err == errNoBackend:
+		log.Printf("No healthy backends available")
+		if grpc {
+			writeGRPCError(w, grpcUnavailable, "no healthy backends available")
+			return
+		}
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	default:
+		log.Printf("Shedding request: %v", err)
+		shedRequest(w, grpc)
+	}
+}
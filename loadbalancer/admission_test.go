@@ -0,0 +1,102 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	"golang-load-balancer/algorithms"
+)
+
+func TestAdmitRequest(t *testing.T) {
+	pool := NewServerPool(algorithms.RoundRobinStrategy)
+	pool.InitStrategy(algorithms.RoundRobinStrategy)
+	b, _ := pool.AddBackendDynamic("http://localhost:8081", 1)
+	limits, _ := newConcurrencyLimits(ConcurrencyConfig{MaxInFlight: 2, MaxInFlightPerBackend: 1}, nil)
+
+	first, err := admitRequest(pool, limits, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the backend is at its own limit: the pick and the global slot are given back
+	if _, err := admitRequest(pool, limits, "192.0.2.1"); err != errOverloaded {
+		t.Errorf("second request = %v, want %v", err, errOverloaded)
+	}
+	if b.GetConnections() != 1 || limits.global.InFlight() != 1 {
+		t.Errorf("%d connections and %d in flight after a rejection, want 1 and 1", b.GetConnections(), limits.global.InFlight())
+	}
+
+	first.responded(200)
+	first.release()
+	if b.GetConnections() != 0 || limits.global.InFlight() != 0 {
+		t.Errorf("%d connections and %d in flight after release, want 0 and 0", b.GetConnections(), limits.global.InFlight())
+	}
+
+	b.SetAlive(false)
+	if _, err := admitRequest(pool, limits, "192.0.2.1"); err != errNoBackend {
+		t.Errorf("request without backends = %v, want %v", err, errNoBackend)
+	}
+	if limits.global.InFlight() != 0 {
+		t.Error("global slot kept without a backend")
+	}
+}
+
+func TestAdmitRequestSkipsFullBackends(t *testing.T) {
+	pool := NewServerPool(algorithms.RoundRobinStrategy)
+	pool.InitStrategy(algorithms.RoundRobinStrategy)
+	b1, _ := pool.AddBackendDynamic("http://localhost:8081", 1)
+	b2, _ := pool.AddBackendDynamic("http://localhost:8082", 1)
+	limits, _ := newConcurrencyLimits(ConcurrencyConfig{MaxInFlight: 10, MaxInFlightPerBackend: 1}, nil)
+
+	first, err := admitRequest(pool, limits, "192.0.2.1")
+	if err != nil || first.backend != b1 {
+		t.Fatalf("first request = %v on %v, want b1", err, first)
+	}
+	second, err := admitRequest(pool, limits, "192.0.2.1")
+	if err != nil || second.backend != b2 {
+		t.Fatalf("second request = %v, want b2", err)
+	}
+	second.release()
+
+	// round robin picks the full b1, b2 has room
+	third, err := admitRequest(pool, limits, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("third request = %v while b2 has room", err)
+	}
+	if third.backend != b2 {
+		t.Errorf("third request went to %s, want b2", third.backend.URL)
+	}
+
+	// now every backend is full
+	if _, err := admitRequest(pool, limits, "192.0.2.1"); err != errOverloaded {
+		t.Errorf("request with every backend full = %v, want %v", err, errOverloaded)
+	}
+	if b1.GetConnections() != 1 || b2.GetConnections() != 1 || limits.global.InFlight() != 2 {
+		t.Errorf("%d and %d connections, %d in flight after a rejection, want 1, 1 and 2",
+			b1.GetConnections(), b2.GetConnections(), limits.global.InFlight())
+	}
+
+	// a draining backend is not a candidate even with room
+	third.release()
+	b2.SetDraining(true)
+	if _, err := admitRequest(pool, limits, "192.0.2.1"); err != errOverloaded {
+		t.Errorf("request with only a draining backend free = %v, want %v", err, errOverloaded)
+	}
+}
+
+func TestAdmitRequestKeepsKeyedClientsOnTheirBackend(t *testing.T) {
+	pool := NewServerPool(algorithms.IPHashStrategy)
+	pool.InitStrategy(algorithms.IPHashStrategy)
+	pool.AddBackendDynamic("http://localhost:8081", 1)
+	pool.AddBackendDynamic("http://localhost:8082", 1)
+	limits, _ := newConcurrencyLimits(ConcurrencyConfig{MaxInFlight: 10, MaxInFlightPerBackend: 1}, nil)
+
+	first, err := admitRequest(pool, limits, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admitRequest(pool, limits, "192.0.2.1"); err != errOverloaded {
+		t.Errorf("second request of the client = %v, want %v", err, errOverloaded)
+	}
+	if other := first.backend.GetConnections(); other != 1 {
+		t.Errorf("%d connections on the client's backend, want 1", other)
+	}
+}
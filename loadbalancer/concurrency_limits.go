@@ -0,0 +1,97 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang-load-balancer/backend"
+	"golang-load-balancer/clock"
+	"golang-load-balancer/concurrency"
+)
+
+// ConcurrencyConfig caps the number of requests in flight
+type ConcurrencyConfig struct {
+	MaxInFlight           int           // across all backends, 0 disables
+	MaxInFlightPerBackend int           // for each backend, 0 disables
+	Adaptive              string        // "", "aimd" or "gradient" to adjust the caps to observed latency
+	Timeout               time.Duration // responses slower than this count as drops with aimd
+}
+
+type concurrencyLimits struct {
+	cfg        ConcurrencyConfig
+	global     *concurrency.Limiter
+	perBackend map[*backend.Backend]*concurrency.Limiter
+	clock      clock.Clock
+	mutex      sync.Mutex
+}
+
+func newConcurrencyLimits(cfg ConcurrencyConfig, clk clock.Clock) (*concurrencyLimits, error) {
+	switch cfg.Adaptive {
+	case "", "off", "aimd", "gradient":
+	default:
+		return nil, fmt.Errorf("unknown adaptive concurrency limit %q, use one of: off, aimd, gradient", cfg.Adaptive)
+	}
+
+	cl := &concurrencyLimits{cfg: cfg, perBackend: make(map[*backend.Backend]*concurrency.Limiter), clock: clk}
+	cl.global = cl.newLimiter(cfg.MaxInFlight)
+	return cl, nil
+}
+
+// newLimiter returns nil when max is 0, which admits every request. Adaptive
+// limits start at half of max and move between 1 and max.
+func (cl *concurrencyLimits) newLimiter(max int) *concurrency.Limiter {
+	if max <= 0 {
+		return nil
+	}
+
+	initial := (max + 1) / 2
+	switch cl.cfg.Adaptive {
+	case "aimd":
+		return concurrency.NewLimiter(concurrency.NewAIMDLimit(initial, max, cl.cfg.Timeout), cl.clock)
+	case "gradient":
+		return concurrency.NewLimiter(concurrency.NewGradientLimit(initial, max), cl.clock)
+	default:
+		return concurrency.NewLimiter(concurrency.FixedLimit(max), cl.clock)
+	}
+}
+
+// forBackend returns the limiter of b, creating it on first use
+func (cl *concurrencyLimits) forBackend(b *backend.Backend) *concurrency.Limiter {
+	if cl.cfg.MaxInFlightPerBackend <= 0 {
+		return nil
+	}
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	l, ok := cl.perBackend[b]
+	if !ok {
+		l = cl.newLimiter(cl.cfg.MaxInFlightPerBackend)
+		cl.perBackend[b] = l
+	}
+	return l
+}
+
+// forget drops the limiter of a backend removed from the pool
+func (cl *concurrencyLimits) forget(b *backend.Backend) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	delete(cl.perBackend, b)
+}
+
+// isOverloaded tells if a backend response means it is struggling with its load
+func isOverloaded(status int) bool {
+	return status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests || status == http.StatusGatewayTimeout
+}
+
+// shedRequest rejects a request the backends have no room for
+func shedRequest(w http.ResponseWriter, grpc bool) {
+	if grpc {
+		writeGRPCError(w, grpcUnavailable, "server overloaded")
+		return
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Service overloaded", http.StatusServiceUnavailable)
+}
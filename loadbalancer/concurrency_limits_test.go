@@ -0,0 +1,30 @@
+package loadbalancer
+
+import "testing"
+
+func TestConcurrencyLimitsForgetRemovedBackends(t *testing.T) {
+	pool := NewServerPool("")
+	a, _ := pool.AddBackendDynamic("http://localhost:8081", 1)
+	b, _ := pool.AddBackendDynamic("http://localhost:8082", 1)
+
+	limits, err := newConcurrencyLimits(ConcurrencyConfig{MaxInFlightPerBackend: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.onRemove = limits.forget
+
+	slot, _ := limits.forBackend(a).Acquire()
+	limits.forBackend(b)
+	if err := pool.RemoveBackendDynamic("http://localhost:8081"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := limits.perBackend[a]; ok || len(limits.perBackend) != 1 {
+		t.Errorf("limiters kept for %d backends after removing one of two", len(limits.perBackend))
+	}
+	slot.Release() // requests still running on the removed backend finish normally
+
+	if limits.forBackend(a) == nil || len(limits.perBackend) != 2 {
+		t.Error("a re-added backend got no limiter")
+	}
+}
@@ -0,0 +1,89 @@
+package loadbalancer
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes used by the load balancer itself
+const (
+	grpcUnknown           = 2
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// writeGRPCError replies with a trailers-only gRPC response carrying the status.
+// gRPC clients ignore the HTTP status, so errors must be reported this way.
+func writeGRPCError(w http.ResponseWriter, code int, message string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", grpcPercentEncode(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcStatusFromHTTP maps a non-gRPC backend reply to a status code as described
+// in the gRPC HTTP/2 protocol spec
+func grpcStatusFromHTTP(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcUnavailable
+	default:
+		return grpcUnknown
+	}
+}
+
+// rewriteNonGRPCResponse turns a plain HTTP error from a backend into a gRPC status,
+// e.g. when a proxy or web server in front of the gRPC service answered instead
+func rewriteNonGRPCResponse(resp *http.Response) {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+		return
+	}
+
+	code := grpcStatusFromHTTP(resp.StatusCode)
+	message := "backend replied with HTTP " + strconv.Itoa(resp.StatusCode)
+
+	resp.Body.Close()
+	resp.Body = io.NopCloser(strings.NewReader(""))
+	resp.ContentLength = 0
+	resp.StatusCode = http.StatusOK
+	resp.Status = "200 OK"
+	resp.Trailer = nil
+	resp.Header = http.Header{
+		"Content-Type": {"application/grpc"},
+		"Grpc-Status":  {strconv.Itoa(code)},
+		"Grpc-Message": {grpcPercentEncode(message)},
+	}
+}
+
+// grpcPercentEncode escapes a grpc-message value as required by the spec
+func grpcPercentEncode(message string) string {
+	var sb strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+		}
+	}
+	return sb.String()
+}
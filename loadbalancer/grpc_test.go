@@ -0,0 +1,100 @@
+package loadbalancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGRPCStatusFromHTTP(t *testing.T) {
+	tests := []struct {
+		status int
+		want   int
+	}{
+		{http.StatusBadRequest, grpcInternal},
+		{http.StatusUnauthorized, grpcUnauthenticated},
+		{http.StatusForbidden, grpcPermissionDenied},
+		{http.StatusNotFound, grpcUnimplemented},
+		{http.StatusTooManyRequests, grpcUnavailable},
+		{http.StatusBadGateway, grpcUnavailable},
+		{http.StatusServiceUnavailable, grpcUnavailable},
+		{http.StatusGatewayTimeout, grpcUnavailable},
+		{http.StatusInternalServerError, grpcUnknown},
+		{http.StatusOK, grpcUnknown},
+		{http.StatusTeapot, grpcUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := grpcStatusFromHTTP(tt.status); got != tt.want {
+			t.Errorf("grpcStatusFromHTTP(%d) = %d, want %d", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestGRPCPercentEncode(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"", ""},
+		{"backend replied with HTTP 503", "backend replied with HTTP 503"},
+		{"100%", "100%25"},
+		{"line\nbreak\t", "line%0Abreak%09"},
+		{"café", "caf%C3%A9"},
+		{"\x7f~ ", "%7F~ "},
+	}
+
+	for _, tt := range tests {
+		if got := grpcPercentEncode(tt.message); got != tt.want {
+			t.Errorf("grpcPercentEncode(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteNonGRPCResponse(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       io.NopCloser(strings.NewReader("<h1>down</h1>")),
+	}
+	rewriteNonGRPCResponse(resp)
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Errorf("got %d with %q, want an empty 200", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Grpc-Status"); got != "14" {
+		t.Errorf("grpc-status = %s, want 14", got)
+	}
+	if got := resp.Header.Get("Grpc-Message"); got != "backend replied with HTTP 503" {
+		t.Errorf("grpc-message = %q", got)
+	}
+
+	// real gRPC replies are left alone
+	grpcResp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/grpc+proto"}}}
+	rewriteNonGRPCResponse(grpcResp)
+	if grpcResp.Header.Get("Grpc-Status") != "" {
+		t.Error("gRPC response was rewritten")
+	}
+}
+
+func TestWriteGRPCError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeGRPCError(rec, grpcResourceExhausted, "rate limit 10%")
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("HTTP status = %d, want 200", rec.Code)
+	}
+	want := http.Header{
+		"Content-Type": {"application/grpc"},
+		"Grpc-Status":  {"8"},
+		"Grpc-Message": {"rate limit 10%25"},
+	}
+	for name, values := range want {
+		if got := rec.Header().Get(name); got != values[0] {
+			t.Errorf("%s = %q, want %q", name, got, values[0])
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package loadbalancer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"golang-load-balancer/clientip"
+)
+
+// HeaderPolicy lists header changes applied to proxied requests and responses.
+// Values may contain the placeholders {client_ip}, {backend_url}, {backend_host},
+// {request_id}, {host}, {scheme}, {method} and {path}.
+type HeaderPolicy struct {
+	RequestAdd     map[string]string `json:"request_add,omitempty"`
+	RequestSet     map[string]string `json:"request_set,omitempty"`
+	RequestRemove  []string          `json:"request_remove,omitempty"`
+	ResponseAdd    map[string]string `json:"response_add,omitempty"`
+	ResponseSet    map[string]string `json:"response_set,omitempty"`
+	ResponseRemove []string          `json:"response_remove,omitempty"`
+}
+
+// requestInfo holds the per-request values that header templates can refer to
+type requestInfo struct {
+	clientIP  string
+	requestID string
+	backend   string
+	host      string
+	scheme    string
+	method    string
+	path      string
+
+	replacer *strings.Replacer // built on first use, shared by all headers of the request
+}
+
+func newRequestInfo(r *http.Request, clientIP string, backendURL string) *requestInfo {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+
+	return &requestInfo{
+		clientIP:  clientIP,
+		requestID: requestID,
+		backend:   backendURL,
+		host:      r.Host,
+		scheme:    requestScheme(r),
+		method:    r.Method,
+		path:      r.URL.Path,
+	}
+}
+
+func (info *requestInfo) expand(value string) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+
+	if info.replacer == nil {
+		backendHost := info.backend
+		if i := strings.Index(backendHost, "://"); i >= 0 {
+			backendHost = backendHost[i+3:]
+		}
+
+		info.replacer = strings.NewReplacer(
+			"{client_ip}", info.clientIP,
+			"{backend_url}", info.backend,
+			"{backend_host}", backendHost,
+			"{request_id}", info.requestID,
+			"{host}", info.host,
+			"{scheme}", info.scheme,
+			"{method}", info.method,
+			"{path}", info.path,
+		)
+	}
+	return info.replacer.Replace(value)
+}
+
+// applyRequest runs the request side of the policy on the outgoing headers
+func (p *HeaderPolicy) applyRequest(h http.Header, info *requestInfo) {
+	if p == nil {
+		return
+	}
+	applyHeaderChanges(h, p.RequestRemove, p.RequestSet, p.RequestAdd, info)
+}
+
+// applyResponse runs the response side of the policy on the backend response headers
+func (p *HeaderPolicy) applyResponse(h http.Header, info *requestInfo) {
+	if p == nil {
+		return
+	}
+	applyHeaderChanges(h, p.ResponseRemove, p.ResponseSet, p.ResponseAdd, info)
+}
+
+// Removals run first so a policy can replace a header by removing and adding it
+func applyHeaderChanges(h http.Header, remove []string, set, add map[string]string, info *requestInfo) {
+	for _, name := range remove {
+		h.Del(name)
+	}
+	for name, value := range set {
+		h.Set(name, info.expand(value))
+	}
+	for name, value := range add {
+		h.Add(name, info.expand(value))
+	}
+}
+
+// setForwardedHeaders rebuilds the forwarding headers on the outgoing request.
+// ReverseProxy strips them when Rewrite is used, so the incoming chain is
+// carried over from pr.In and the directly connected peer is appended to it.
+// X-Forwarded-Host and -Proto are only passed through from trusted proxies.
+func setForwardedHeaders(pr *httputil.ProxyRequest, resolver *clientip.Resolver) {
+	in, out := pr.In, pr.Out
+	peer := clientip.StripPort(in.RemoteAddr)
+
+	xff := strings.Join(in.Header.Values("X-Forwarded-For"), ", ")
+	if xff != "" {
+		xff += ", "
+	}
+	out.Header.Set("X-Forwarded-For", xff+peer)
+
+	host, proto := in.Host, requestScheme(in)
+	if resolver.IsTrusted(peer) {
+		if h := in.Header.Get("X-Forwarded-Host"); h != "" {
+			host = h
+		}
+		if p := in.Header.Get("X-Forwarded-Proto"); p != "" {
+			proto = p
+		}
+	}
+	out.Header.Set("X-Forwarded-Host", host)
+	out.Header.Set("X-Forwarded-Proto", proto)
+
+	element := "for=" + forwardedNode(peer) +
+		";host=" + forwardedValue(in.Host) +
+		";proto=" + requestScheme(in)
+	if prior := strings.Join(in.Header.Values("Forwarded"), ", "); prior != "" {
+		element = prior + ", " + element
+	}
+	out.Header.Set("Forwarded", element)
+}
+
+// forwardedNode formats an address as a RFC 7239 node, quoting IPv6 literals
+func forwardedNode(ip string) string {
+	if ip == "" {
+		return "unknown"
+	}
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// forwardedValue quotes a RFC 7239 value when it is not a plain token
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
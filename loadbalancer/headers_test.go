@@ -0,0 +1,165 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+
+	"golang-load-balancer/clientip"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/api/users", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	info := newRequestInfo(r, "203.0.113.7", "http://10.0.0.1:8081")
+
+	policy := &HeaderPolicy{
+		RequestRemove: []string{"Cookie", "X-Debug"},
+		RequestSet: map[string]string{
+			"X-Client":  "{client_ip}",
+			"X-Backend": "{backend_host} via {scheme}://{host}{path}",
+			"X-Debug":   "set after remove",
+		},
+		RequestAdd: map[string]string{"X-Trace": "{request_id}/{method}"},
+	}
+
+	h := http.Header{}
+	h.Set("Cookie", "secret")
+	h.Set("X-Debug", "from client")
+	h.Add("X-Trace", "upstream")
+	policy.applyRequest(h, info)
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Cookie", nil},
+		{"X-Debug", []string{"set after remove"}},
+		{"X-Client", []string{"203.0.113.7"}},
+		{"X-Backend", []string{"10.0.0.1:8081 via http://example.com/api/users"}},
+		{"X-Trace", []string{"upstream", "req-1/GET"}},
+	}
+	for _, tt := range tests {
+		got := h.Values(tt.name)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+			}
+		}
+	}
+
+	var nilPolicy *HeaderPolicy
+	nilPolicy.applyResponse(h, info) // routes without a policy
+}
+
+func TestRequestIDIsGeneratedWhenMissing(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	a := newRequestInfo(r, "", "").requestID
+	b := newRequestInfo(r, "", "").requestID
+	if len(a) != 32 || a == b {
+		t.Errorf("request IDs %q and %q are not unique 16-byte hex values", a, b)
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		header        map[string]string
+		wantXFF       string
+		wantHost      string
+		wantProto     string
+		wantForwarded string
+	}{
+		{
+			name:          "direct client",
+			remoteAddr:    "203.0.113.7:5000",
+			wantXFF:       "203.0.113.7",
+			wantHost:      "example.com",
+			wantProto:     "http",
+			wantForwarded: "for=203.0.113.7;host=example.com;proto=http",
+		},
+		{
+			name:       "untrusted client cannot set host or proto",
+			remoteAddr: "203.0.113.7:5000",
+			header: map[string]string{
+				"X-Forwarded-For":   "1.2.3.4",
+				"X-Forwarded-Host":  "evil.com",
+				"X-Forwarded-Proto": "https",
+			},
+			wantXFF:       "1.2.3.4, 203.0.113.7",
+			wantHost:      "example.com",
+			wantProto:     "http",
+			wantForwarded: "for=203.0.113.7;host=example.com;proto=http",
+		},
+		{
+			name:       "trusted proxy passes host and proto through",
+			remoteAddr: "10.1.2.3:5000",
+			header: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1",
+				"X-Forwarded-Host":  "public.example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=198.51.100.1",
+			},
+			wantXFF:       "198.51.100.1, 10.1.2.3",
+			wantHost:      "public.example.com",
+			wantProto:     "https",
+			wantForwarded: "for=198.51.100.1, for=10.1.2.3;host=example.com;proto=http",
+		},
+		{
+			name:          "IPv6 peer is quoted",
+			remoteAddr:    "[2001:db8::1]:5000",
+			wantXFF:       "2001:db8::1",
+			wantHost:      "example.com",
+			wantProto:     "http",
+			wantForwarded: `for="[2001:db8::1]";host=example.com;proto=http`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := httptest.NewRequest("GET", "http://example.com/", nil)
+			in.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				in.Header.Set(k, v)
+			}
+			pr := &httputil.ProxyRequest{In: in, Out: in.Clone(in.Context())}
+			pr.Out.Header = http.Header{}
+			setForwardedHeaders(pr, resolver)
+
+			for name, want := range map[string]string{
+				"X-Forwarded-For":   tt.wantXFF,
+				"X-Forwarded-Host":  tt.wantHost,
+				"X-Forwarded-Proto": tt.wantProto,
+				"Forwarded":         tt.wantForwarded,
+			} {
+				if got := pr.Out.Header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedValue(t *testing.T) {
+	tests := map[string]string{
+		"example.com":      "example.com",
+		"example.com:8080": `"example.com:8080"`,
+		`a"b`:              `"a\"b"`,
+	}
+	for in, want := range tests {
+		if got := forwardedValue(in); got != want {
+			t.Errorf("forwardedValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
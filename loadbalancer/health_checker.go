@@ -1,19 +1,37 @@
 package loadbalancer
 
 import (
+	"net/http"
 	"time"
 
 	"golang-load-balancer/backend"
 )
 
 func StartHealthChecker(pool *ServerPool, interval time.Duration) {
+	client := &http.Client{Transport: pool.Transport(), Timeout: 5 * time.Second}
+
 	go func() {
 		for {
-			for _, b := range pool.GetBackends() {
-				alive := backend.CheckBackendHealth(b.URL)
+			for _, b := range pool.snapshot() { // backends may be added or removed meanwhile
+				if b.URL.Scheme == "udp" {
+					continue // UDP has no generic liveness probe
+				}
+
+				var alive bool
+				if b.URL.Scheme == "tcp" {
+					alive = backend.CheckTCPHealth(b.URL.Host, 5*time.Second)
+				} else if pool.grpcHealth {
+					alive = backend.CheckGRPCHealth(client, b.URL, pool.grpcHealthService)
+				} else {
+					alive = backend.CheckBackendHealth(client, b.URL)
+				}
+				wasAlive := b.IsHealthy()
 				b.SetAlive(alive)
+				if alive && !wasAlive {
+					pool.notifyCapacity() // queued requests can use it now
+				}
 			}
-			time.Sleep(interval)
+			pool.clock.Sleep(interval)
 		}
 	}()
 }
@@ -0,0 +1,95 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestHealthCheckerInterval(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	var checks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	pool := NewServerPool("")
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	pool.SetClock(clk)
+	b, err := pool.AddBackendDynamic(server.URL, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the checker sleeps on the fake clock after every round of checks
+	waitForRound := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for clk.Waiters() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("health checker did not finish a round")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	StartHealthChecker(pool, 10*time.Second)
+	waitForRound()
+	if !b.IsAlive() || checks.Load() != 1 {
+		t.Fatalf("after first round: alive = %v, checks = %d", b.IsAlive(), checks.Load())
+	}
+
+	healthy.Store(false)
+	clk.Advance(9 * time.Second)
+	if checks.Load() != 1 {
+		t.Fatalf("checked again before the interval passed")
+	}
+
+	clk.Advance(time.Second)
+	waitForRound()
+	if b.IsAlive() || checks.Load() != 2 {
+		t.Fatalf("after second round: alive = %v, checks = %d", b.IsAlive(), checks.Load())
+	}
+
+	healthy.Store(true)
+	clk.Advance(10 * time.Second)
+	waitForRound()
+	if !b.IsAlive() {
+		t.Fatal("backend did not come back alive")
+	}
+}
+
+// run with -race: rounds must not read the backend list while the admin API changes it
+func TestHealthCheckerWhileBackendsChange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	pool := NewServerPool("")
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	pool.SetClock(clk)
+	if _, err := pool.AddBackendDynamic(server.URL, 1); err != nil {
+		t.Fatal(err)
+	}
+	StartHealthChecker(pool, time.Second)
+
+	for i := 0; i < 20; i++ {
+		url := fmt.Sprintf("http://127.0.0.1:%d", 10000+i)
+		if _, err := pool.AddBackendDynamic(url, 1); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Second)
+		if err := pool.RemoveBackendDynamic(url); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang-load-balancer/clientip"
+	"golang-load-balancer/ratelimiter"
+)
+
+// Priority is the class of a request. Under overload lower classes are shed
+// first, and queued requests of higher classes are served first.
+type Priority int
+
+const (
+	priorityUnset Priority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:      "low",
+	PriorityNormal:   "normal",
+	PriorityHigh:     "high",
+	PriorityCritical: "critical",
+}
+
+func ParsePriority(s string) (Priority, error) {
+	for p, name := range priorityNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return priorityUnset, fmt.Errorf("unknown priority %q, use one of: low, normal, high, critical", s)
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return "normal"
+}
+
+func (p *Priority) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("priority must be a string: %v", err)
+	}
+	parsed, err := ParsePriority(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// ClientTiers gives clients a priority, e.g. paying customers by their API key
+type ClientTiers struct {
+	Key   string              `json:"key"` // ratelimiter.ParseKey spec, client IP if empty
+	Tiers map[string]Priority `json:"tiers"`
+	key   ratelimiter.KeyFunc
+}
+
+// LoadClientTiers reads client tiers from a JSON file like
+// {"key": "header:X-API-Key", "tiers": {"k-123": "critical", "k-free": "low"}}
+func LoadClientTiers(path string, resolver *clientip.Resolver) (*ClientTiers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tiers := &ClientTiers{}
+	if err := json.Unmarshal(data, tiers); err != nil {
+		return nil, fmt.Errorf("parsing client tiers %s: %v", path, err)
+	}
+	if tiers.key, err = ratelimiter.ParseKey(tiers.Key, resolver.ClientIP); err != nil {
+		return nil, fmt.Errorf("client tiers %s: %v", path, err)
+	}
+	return tiers, nil
+}
+
+// PriorityConfig decides the priority of requests and when to shed them
+type PriorityConfig struct {
+	Header  string       // priority class set by a trusted proxy, e.g. X-Priority: critical
+	Tiers   *ClientTiers // optional
+	Default Priority     // of requests nothing else applies to, normal if unset
+
+	// ShedAt maps a class to the load at which its requests are shed. Load is
+	// the fraction of the global in-flight limit in use, or of ShedQueueDepth
+	// queued requests, whichever is higher. Classes without a threshold are only
+	// turned away when there is no room at all.
+	ShedAt         map[Priority]float64
+	ShedQueueDepth int
+}
+
+// ParseShedThresholds parses "low=0.5,normal=0.8,high=0.95". Loads are
+// fractions of the capacity, so they must be above 0 and at most 1.
+func ParseShedThresholds(s string) (map[Priority]float64, error) {
+	thresholds := make(map[Priority]float64)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid shed threshold %q, use class=load", pair)
+		}
+		p, err := ParsePriority(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		load, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || load <= 0 || load > 1 {
+			return nil, fmt.Errorf("invalid shed threshold %q, the load must be above 0 and at most 1", pair)
+		}
+		thresholds[p] = load
+	}
+	return thresholds, nil
+}
+
+// requestPriority picks the class of a request: a header from a trusted proxy
+// first, then the client's tier, then the route's class
+func requestPriority(r *http.Request, route *Route, cfg *ProxyConfig) Priority {
+	pc := cfg.Priority
+
+	if pc.Header != "" {
+		if value := r.Header.Get(pc.Header); value != "" && cfg.ClientIP.IsTrusted(clientip.StripPort(r.RemoteAddr)) {
+			if p, err := ParsePriority(value); err == nil {
+				return p
+			}
+		}
+	}
+	if pc.Tiers != nil {
+		if p, ok := pc.Tiers.Tiers[pc.Tiers.key(r)]; ok {
+			return p
+		}
+	}
+	if route.Priority != priorityUnset {
+		return route.Priority
+	}
+	if pc.Default != priorityUnset {
+		return pc.Default
+	}
+	return PriorityNormal
+}
+
+// loadShedder rejects lower priority requests first as the load balancer fills up
+type loadShedder struct {
+	cfg    PriorityConfig
+	limits *concurrencyLimits
+	queue  *waitQueue
+}
+
+// load returns how full the load balancer is, 1 meaning no room left
+func (ls *loadShedder) load() float64 {
+	var load float64
+	if global := ls.limits.global; global != nil {
+		load = float64(global.InFlight()) / float64(max(global.Limit(), 1))
+	}
+	if ls.cfg.ShedQueueDepth > 0 {
+		load = max(load, float64(ls.queue.Len())/float64(ls.cfg.ShedQueueDepth))
+	}
+	return load
+}
+
+// shed tells if a request of the given priority should be turned away
+func (ls *loadShedder) shed(p Priority) bool {
+	threshold, ok := ls.cfg.ShedAt[p]
+	if !ok {
+		return false
+	}
+	load := ls.load()
+	if load < threshold {
+		return false
+	}
+	log.Printf("Shedding %s priority request at load %.2f", p, load)
+	return true
+}
@@ -0,0 +1,111 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang-load-balancer/clientip"
+	"golang-load-balancer/clock"
+)
+
+func TestRequestPriority(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiers := &ClientTiers{
+		Tiers: map[string]Priority{"k-enterprise": PriorityCritical, "k-free": PriorityLow},
+		key:   func(r *http.Request) string { return r.Header.Get("X-API-Key") },
+	}
+
+	tests := []struct {
+		name     string
+		remote   string
+		headers  map[string]string
+		tiers    *ClientTiers
+		route    Priority
+		fallback Priority // PriorityConfig.Default
+		want     Priority
+	}{
+		{name: "nothing set", remote: "192.0.2.1:1234", want: PriorityNormal},
+		{name: "default", remote: "192.0.2.1:1234", fallback: PriorityLow, want: PriorityLow},
+		{name: "route over default", remote: "192.0.2.1:1234", route: PriorityHigh, fallback: PriorityLow, want: PriorityHigh},
+		{name: "tier over route", remote: "192.0.2.1:1234", tiers: tiers, headers: map[string]string{"X-API-Key": "k-free"}, route: PriorityHigh, want: PriorityLow},
+		{name: "unknown client falls back to route", remote: "192.0.2.1:1234", tiers: tiers, headers: map[string]string{"X-API-Key": "k-other"}, route: PriorityHigh, want: PriorityHigh},
+		{name: "trusted header over tier", remote: "10.1.2.3:1234", tiers: tiers, headers: map[string]string{"X-Priority": "low", "X-API-Key": "k-enterprise"}, want: PriorityLow},
+		{name: "trusted header is case insensitive", remote: "10.1.2.3:1234", headers: map[string]string{"X-Priority": "CRITICAL"}, want: PriorityCritical},
+		{name: "untrusted header is ignored", remote: "192.0.2.1:1234", headers: map[string]string{"X-Priority": "critical"}, route: PriorityLow, want: PriorityLow},
+		{name: "invalid header value is ignored", remote: "10.1.2.3:1234", tiers: tiers, headers: map[string]string{"X-Priority": "urgent", "X-API-Key": "k-enterprise"}, want: PriorityCritical},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		cfg := &ProxyConfig{ClientIP: resolver, Priority: PriorityConfig{Header: "X-Priority", Tiers: tt.tiers, Default: tt.fallback}}
+
+		if got := requestPriority(r, &Route{Priority: tt.route}, cfg); got != tt.want {
+			t.Errorf("%s: priority = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadShedder(t *testing.T) {
+	shedAt := map[Priority]float64{PriorityLow: 0.5, PriorityNormal: 0.8, PriorityHigh: 1}
+
+	tests := []struct {
+		name     string
+		inflight int // of a global limit of 10
+		queued   int // with a shed queue depth of 20
+		shed     []Priority
+	}{
+		{name: "idle", shed: nil},
+		{name: "below every threshold", inflight: 4, shed: nil},
+		{name: "at the low threshold", inflight: 5, shed: []Priority{PriorityLow}},
+		{name: "at the normal threshold", inflight: 8, shed: []Priority{PriorityLow, PriorityNormal}},
+		{name: "full", inflight: 10, shed: []Priority{PriorityLow, PriorityNormal, PriorityHigh}},
+		{name: "queue fuller than the limit", inflight: 2, queued: 17, shed: []Priority{PriorityLow, PriorityNormal}},
+		{name: "queue past its depth", queued: 25, shed: []Priority{PriorityLow, PriorityNormal, PriorityHigh}},
+	}
+
+	for _, tt := range tests {
+		limits, _ := newConcurrencyLimits(ConcurrencyConfig{MaxInFlight: 10}, nil)
+		for range tt.inflight {
+			limits.global.Acquire()
+		}
+		queue := newWaitQueue(clock.Real)
+		for range tt.queued {
+			queue.waiters = append(queue.waiters, &waiter{})
+		}
+		ls := &loadShedder{cfg: PriorityConfig{ShedAt: shedAt, ShedQueueDepth: 20}, limits: limits, queue: queue}
+
+		for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityCritical} {
+			want := false
+			for _, s := range tt.shed {
+				want = want || s == p
+			}
+			if got := ls.shed(p); got != want {
+				t.Errorf("%s: shed(%s) = %v, want %v", tt.name, p, got, want)
+			}
+		}
+	}
+}
+
+func TestParseShedThresholds(t *testing.T) {
+	got, err := ParseShedThresholds(" low=0.6, normal=0.8,high=1,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[PriorityLow] != 0.6 || got[PriorityNormal] != 0.8 || got[PriorityHigh] != 1 {
+		t.Errorf("thresholds = %v", got)
+	}
+
+	for _, s := range []string{"low", "low=", "low=abc", "low=0", "low=-0.5", "low=1.5", "urgent=0.5"} {
+		if _, err := ParseShedThresholds(s); err == nil {
+			t.Errorf("ParseShedThresholds(%q) accepted", s)
+		}
+	}
+}
@@ -1,82 +1,58 @@
 package loadbalancer
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
-	"golang-load-balancer/algorithms"
 	"golang-load-balancer/backend"
+	"golang-load-balancer/clientip"
+	"golang-load-balancer/clock"
+	"golang-load-balancer/quota"
 	"golang-load-balancer/ratelimiter"
 )
 
-// Custom ResponseWriter to detect when the response is sent
+// Custom ResponseWriter that tracks connections upgraded to tunnels (e.g. WebSockets)
 type responseWriter struct {
 	http.ResponseWriter
 	backend     *backend.Backend
-	wroteHeader bool
+	pool        *ServerPool
+	idleTimeout time.Duration
 }
 
-func (rw *responseWriter) WriteHeader(statusCode int) {
-	if !rw.wroteHeader {
-		rw.wroteHeader = true
-		rw.backend.DecrementConnections()
-		// log.Printf("Decremented. Server %s now has %d active connections", rw.backend.URL.String(), rw.backend.GetConnections())
-	}
-	rw.ResponseWriter.WriteHeader(statusCode)
-}
-
-func (rw *responseWriter) Write(b []byte) (int, error) {
-	if !rw.wroteHeader {
-		// Ensure Decrement is called even if WriteHeader wasn't explicitly called
-		rw.WriteHeader(http.StatusOK)
-	}
-	return rw.ResponseWriter.Write(b)
-}
-
-// for per-client-ip rate limiting
-var (
-	limiterTypeGlobal string
-	rateGlobal        int
-	burstGlobal       int
-	clientLimiters    = make(map[string]ratelimiter.Limiter)
-	clientLimiterLock = sync.RWMutex{}
-)
-
-func allowRequest(r *http.Request) bool {
-	if limiterTypeGlobal == "none" {
-		return true // no limiting needed
+// Hijack hands the client connection to ReverseProxy for protocol switches.
+// ServeHTTP only returns once the tunnel is closed, so the backend's connection
+// count stays held for the whole lifetime of the tunnel.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	clientIP := r.RemoteAddr
-	if ip := r.Header.Get("X-Real-IP"); ip != "" {
-		clientIP = ip
-	} else if ip = r.Header.Get("X-Forwarded-For"); ip != "" {
-		clientIP = strings.Split(ip, ",")[0]
+	tunnel := &tunnelConn{Conn: conn, idleTimeout: rw.idleTimeout}
+	tunnel.touch()
+	tunnel.onClose = func() { rw.pool.tunnels.remove(rw.backend, tunnel) }
+	rw.pool.tunnels.add(rw.backend, tunnel)
+	if rw.backend.IsDraining() {
+		tunnel.drain(0)
 	}
 
-	clientLimiterLock.RLock()
-	limiter, exists := clientLimiters[clientIP]
-	clientLimiterLock.RUnlock()
-
-	if !exists {
-		clientLimiterLock.Lock()
-		limiter = ratelimiter.NewLimiter(limiterTypeGlobal, rateGlobal, burstGlobal)
-		clientLimiters[clientIP] = limiter
-		clientLimiterLock.Unlock()
-		log.Printf("Created new limiter for client %s", clientIP)
-	}
+	log.Printf("Opened tunnel to %s", rw.backend.URL.String())
+	return tunnel, brw, nil
+}
 
-	if !limiter.Allow(r) {
-		log.Printf("Rate limit exceeded for client %s", clientIP)
-		return false
-	}
-	return true
+// Unwrap lets http.ResponseController reach Flush on the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
 // AddBackend adds a backend to the pool and starts a dummy server
@@ -163,51 +139,216 @@ func removeBackend(w http.ResponseWriter, r *http.Request, pool *ServerPool) {
 	fmt.Fprintf(w, "Backend removed: %s", url)
 }
 
-func StartProxy(port string, pool *ServerPool, limiterType string, rate int, burst int) {
-	router := http.NewServeMux()
+// proxyHandler forwards requests on a route to the next backend picked by the pool
+func proxyHandler(pool *ServerPool, route *Route, globalRules []*ratelimiter.CompiledRule, limits *concurrencyLimits, shedder *loadShedder, cfg *ProxyConfig) http.HandlerFunc {
+	var clientCertHeader string
+	if cfg.TLS != nil {
+		clientCertHeader = cfg.TLS.ClientCertHeader
+	}
+
+	policy, err := routePolicy(route, globalRules, cfg)
+	if err != nil {
+		log.Fatalf("Invalid rate limits on route %s: %v", route.Path, err)
+	}
 
-	limiterTypeGlobal = limiterType
-	rateGlobal = rate
-	burstGlobal = burst
+	queue := route.Queue
+	if queue == nil {
+		queue = cfg.Queue
+	}
+	if queue != nil && queue.Size <= 0 {
+		queue = nil
+	}
 
-	router.HandleFunc("/loadbalancer", func(w http.ResponseWriter, r *http.Request) {
-		// check if client is requesting within limit
-		if !allowRequest(r) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientIP := cfg.ClientIP.ClientIP(r)
+		grpc := route.GRPC || isGRPCRequest(r)
+
+		// check if the request is within every limit that applies to it
+		decision := policy.AllowN(r, route.cost())
+		decision.SetHeaders(w.Header())
+		if !decision.Allowed {
+			if grpc {
+				writeGRPCError(w, grpcResourceExhausted, "rate limit exceeded")
+				return
+			}
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
 
-		// serve next backend if allowed
-		backend := pool.GetNextBackend()
-		if backend == nil {
-			log.Printf("No healthy backends available")
-			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		// check the client's daily and monthly quotas, the units are given back
+		// if the request is turned away below
+		refundQuota := func() {}
+		if cfg.Quotas != nil {
+			status := cfg.Quotas.Allow(r, route.cost())
+			status.SetHeaders(w.Header())
+			if !status.Allowed {
+				if grpc {
+					writeGRPCError(w, grpcResourceExhausted, "quota exceeded")
+					return
+				}
+				http.Error(w, "Quota exceeded", http.StatusTooManyRequests)
+				return
+			}
+			refundQuota = func() { cfg.Quotas.Refund(r, route.cost()) }
+		}
+
+		// turn away lower priorities first as the backends fill up
+		priority := requestPriority(r, route, cfg)
+		if shedder.shed(priority) {
+			refundQuota()
+			shedRequest(w, grpc)
+			return
+		}
+
+		// serve next backend if there is room for the request, shed load beyond
+		// the requests the backends can have in flight unless the route queues
+		var adm *admission
+		try := func() (err error) {
+			adm, err = admitRequest(pool, limits, clientIP)
+			return err
+		}
+		var err error
+		if queue != nil && pool.waiters.Len() > 0 {
+			err = pool.waiters.wait(r.Context(), route.Path, queue, priority, try) // line up behind earlier requests
+		} else if err = try(); err != nil && queue != nil {
+			err = pool.waiters.wait(r.Context(), route.Path, queue, priority, try)
+		}
+		if err != nil {
+			refundQuota()
+			rejectRequest(w, grpc, err)
 			return
 		}
+		defer adm.release()
 
+		backend := adm.backend
 		target := backend.URL
-		r.URL.Scheme = target.Scheme
-		r.URL.Host = target.Host
-		r.URL.Path = target.Path + r.URL.Path
+		info := newRequestInfo(r, clientIP, target.String())
 
-		log.Printf("Forwarding request to: %s", target.String())
+		log.Printf("Forwarding request %s to: %s", info.requestID, target.String())
 
-		proxy := httputil.NewSingleHostReverseProxy(target)
-		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-			log.Printf("Proxy error: %v", err)
-			w.WriteHeader(http.StatusServiceUnavailable)
-			fmt.Fprint(w, "Service unavailable")
+		if pool.proxyProtocol != 0 {
+			r = withProxyAddrs(r, clientIP)
 		}
 
-		// Use custom writer only for LeastConnections
-		if pool.GetStrategyType() == algorithms.LeastConnectionsStrategy {
-			proxy.ServeHTTP(&responseWriter{ResponseWriter: w, backend: backend}, r)
-		} else {
-			proxy.ServeHTTP(w, r)
+		proxy := &httputil.ReverseProxy{
+			Transport: pool.Transport(),
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.Out.Host = pr.In.Host
+				setForwardedHeaders(pr, cfg.ClientIP)
+				setClientCertHeader(pr.In, pr.Out.Header, clientCertHeader)
+				route.Headers.applyRequest(pr.Out.Header, info)
+			},
+			ModifyResponse: func(resp *http.Response) error {
+				adm.responded(resp.StatusCode)
+				chargeReportedCost(resp, r, route, policy, cfg.Quotas)
+				if grpc {
+					rewriteNonGRPCResponse(resp)
+				}
+				route.Headers.applyResponse(resp.Header, info)
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				log.Printf("Proxy error: %v", err)
+				if !errors.Is(err, context.Canceled) { // the client went away, not the backend's fault
+					backend.RecordRequest(true)
+					adm.drop()
+				}
+				if grpc {
+					writeGRPCError(w, grpcUnavailable, "backend unavailable")
+					return
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, "Service unavailable")
+			},
 		}
-	})
 
-	router.HandleFunc("/admin/addBackend", func(w http.ResponseWriter, r *http.Request) {
+		proxy.ServeHTTP(&responseWriter{
+			ResponseWriter: w,
+			backend:        backend,
+			pool:           pool,
+			idleTimeout:    cfg.TunnelIdleTimeout,
+		}, r)
+	}
+}
+
+// ProxyConfig holds the settings StartProxy needs to serve traffic
+type ProxyConfig struct {
+	Addr       string
+	Routes     []*Route
+	RateLimits []ratelimiter.Rule       // rules applied on every route, see routePolicy
+	Counters   ratelimiter.CounterStore // counters of shared rules, shared with other instances
+	PeerAddr   string                   // listener receiving peer counts when Counters is a PeerCounterStore
+	MaxClients int                      // most clients tracked per rule, least recently seen are dropped first
+	ClientTTL  time.Duration            // forget rate limit state of clients idle for this long
+	ClientIP   *clientip.Resolver       // decides which forwarding headers to trust
+	TLS        *TLSConfig               // optional HTTPS listener
+	Quotas     *quota.Manager           // daily and monthly quotas, nil disables
+
+	Concurrency ConcurrencyConfig // caps on requests in flight
+	Queue       *QueueConfig      // queue of routes without their own, nil disables
+	QueueOrder  string            // "fifo" or "priority"
+	Priority    PriorityConfig    // request classes and when to shed them
+
+	ProxyProtocol bool // accept PROXY protocol headers from trusted proxies
+
+	TunnelIdleTimeout time.Duration // close upgraded connections idle for this long, 0 disables
+	H2C               bool          // accept cleartext HTTP/2 with prior knowledge on the plain listener
+
+	Admin AdminConfig // listener and credentials of the admin API
+
+	clock clock.Clock // taken from the pool by StartProxy
+}
+
+// drainBackend stops new traffic to a backend and closes its tunnels after a grace period
+func drainBackend(w http.ResponseWriter, r *http.Request, pool *ServerPool) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "URL parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	grace := 30 * time.Second
+	if graceStr := r.URL.Query().Get("grace"); graceStr != "" {
+		parsed, err := time.ParseDuration(graceStr)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid grace duration", http.StatusBadRequest)
+			return
+		}
+		grace = parsed
+	}
+
+	b := pool.FindBackend(url)
+	if b == nil {
+		http.Error(w, fmt.Sprintf("Backend %s not found", url), http.StatusNotFound)
+		return
+	}
+	pool.DrainBackend(b, grace)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Backend draining: %s", url)
+}
+
+// adminRoutes serves the JSON admin API next to the older admin endpoints
+func adminRoutes(pool *ServerPool, limits *concurrencyLimits, cfg *ProxyConfig) *http.ServeMux {
+	admin := http.NewServeMux()
+	admin.Handle(adminPrefix+"/", newAdminAPI(map[string]*ServerPool{defaultPool: pool}, limits))
+	admin.Handle("/admin/queue", pool.waiters)
+
+	if cfg.Quotas != nil {
+		admin.HandleFunc("/admin/quota", func(w http.ResponseWriter, r *http.Request) {
+			quotaUsage(w, r, cfg.Quotas)
+		})
+		admin.HandleFunc("/admin/quota/reset", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Only POST method allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			resetQuota(w, r, cfg.Quotas)
+		})
+	}
+
+	admin.HandleFunc("/admin/addBackend", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Only POST method allowed", http.StatusMethodNotAllowed)
 			return
@@ -215,7 +356,7 @@ func StartProxy(port string, pool *ServerPool, limiterType string, rate int, bur
 		addBackend(w, r, pool)
 	})
 
-	router.HandleFunc("/admin/removeBackend", func(w http.ResponseWriter, r *http.Request) {
+	admin.HandleFunc("/admin/removeBackend", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Only POST method allowed", http.StatusMethodNotAllowed)
 			return
@@ -223,6 +364,136 @@ func StartProxy(port string, pool *ServerPool, limiterType string, rate int, bur
 		removeBackend(w, r, pool)
 	})
 
-	log.Printf("Starting Load Balancer on %s", port)
-	log.Fatal(http.ListenAndServe(port, router))
+	admin.HandleFunc("/admin/drainBackend", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		drainBackend(w, r, pool)
+	})
+	return admin
+}
+
+// StartProxy serves traffic until ctx is done, then shuts every listener down,
+// pushes the last counts to its peers and saves the quota usage before returning
+func StartProxy(ctx context.Context, pool *ServerPool, cfg ProxyConfig) {
+	router := http.NewServeMux()
+	cfg.clock = pool.clock
+
+	globalRules, err := compileRules(cfg.RateLimits, "", &cfg)
+	if err != nil {
+		log.Fatalf("Invalid rate limits: %v", err)
+	}
+	limits, err := newConcurrencyLimits(cfg.Concurrency, cfg.clock)
+	if err != nil {
+		log.Fatalf("Invalid concurrency limits: %v", err)
+	}
+	pool.mutex.Lock()
+	pool.onRemove = limits.forget
+	pool.mutex.Unlock()
+	if err := pool.waiters.SetOrder(cfg.QueueOrder); err != nil {
+		log.Fatalf("Invalid request queue: %v", err)
+	}
+	shedder := &loadShedder{cfg: cfg.Priority, limits: limits, queue: pool.waiters}
+
+	for _, route := range cfg.Routes {
+		router.HandleFunc(route.Path, proxyHandler(pool, route, globalRules, limits, shedder, &cfg))
+	}
+	var servers []*http.Server
+
+	// Instances syncing counters among themselves push their counts to a listener
+	// of their own, away from client traffic and the HTTPS redirect
+	peers, _ := cfg.Counters.(*ratelimiter.PeerCounterStore)
+	if peers != nil {
+		server, err := startPeerSync(cfg.PeerAddr, peers)
+		if err != nil {
+			log.Fatalf("Rate limit peer sync setup failed: %v", err)
+		}
+		servers = append(servers, server)
+	}
+
+	// The admin API has its own listener so it is never exposed with the proxy
+	if cfg.Admin.Addr != "" {
+		server, err := startAdmin(cfg.Admin, adminRoutes(pool, limits, &cfg), cfg.clock)
+		if err != nil {
+			log.Fatalf("Admin API setup failed: %v", err)
+		}
+		servers = append(servers, server)
+	}
+
+	plainHandler := http.Handler(router)
+	if cfg.TLS != nil {
+		tlsServer, err := newTLSServer(cfg.TLS, router, cfg.clock)
+		if err != nil {
+			log.Fatalf("TLS setup failed: %v", err)
+		}
+		if cfg.TLS.RedirectHTTP {
+			plainHandler = redirectToHTTPS(cfg.TLS.Addr)
+		}
+
+		tlsListener, err := listen(cfg.TLS.Addr, cfg.ProxyProtocol, cfg.ClientIP)
+		if err != nil {
+			log.Fatalf("TLS listen on %s failed: %v", cfg.TLS.Addr, err)
+		}
+
+		servers = append(servers, tlsServer)
+		go func() {
+			log.Printf("Starting Load Balancer (TLS) on %s", cfg.TLS.Addr)
+			if err := tlsServer.ServeTLS(tlsListener, "", ""); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	listener, err := listen(cfg.Addr, cfg.ProxyProtocol, cfg.ClientIP)
+	if err != nil {
+		log.Fatalf("Listen on %s failed: %v", cfg.Addr, err)
+	}
+
+	// h2c lets gRPC clients use HTTP/2 without TLS
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+	server := &http.Server{Handler: plainHandler, Protocols: protocols}
+	servers = append(servers, server)
+
+	go func() {
+		log.Printf("Starting Load Balancer on %s", cfg.Addr)
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	shutdown(servers)
+	if peers != nil {
+		peers.Close()
+	}
+	if cfg.Quotas != nil {
+		if err := cfg.Quotas.Save(); err != nil {
+			log.Printf("Saving quota usage failed: %v", err)
+		}
+	}
+}
+
+// shutdownTimeout bounds how long requests in flight may take to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// shutdown stops all servers at once and waits for their requests in flight
+func shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Shutdown failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
 }
@@ -0,0 +1,81 @@
+package loadbalancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"golang-load-balancer/clientip"
+	"golang-load-balancer/proxyproto"
+)
+
+// listen opens a TCP listener, parsing PROXY protocol headers from trusted peers if enabled
+func listen(addr string, acceptProxyProtocol bool, resolver *clientip.Resolver) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !acceptProxyProtocol {
+		return listener, nil
+	}
+	return &proxyproto.Listener{
+		Listener:      listener,
+		Trusted:       resolver.IsTrusted,
+		HeaderTimeout: 5 * time.Second,
+	}, nil
+}
+
+// writeProxyHeader announces the client connection to the backend on upstream
+func writeProxyHeader(upstream net.Conn, version int, client, local net.Addr) error {
+	header, err := proxyproto.Format(version, client, local)
+	if err != nil {
+		return err
+	}
+	_, err = upstream.Write(header)
+	return err
+}
+
+// proxyAddrsKey carries the client and local address of a request to the upstream dialer
+type proxyAddrsKey struct{}
+
+type proxyAddrs struct {
+	client net.Addr
+	local  net.Addr
+}
+
+// withProxyAddrs stores the addresses the PROXY header sent upstream should announce
+func withProxyAddrs(r *http.Request, clientIP string) *http.Request {
+	client := &net.TCPAddr{IP: net.ParseIP(clientIP)}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil && addr.IP.Equal(client.IP) {
+		client.Port = addr.Port
+	}
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+
+	ctx := context.WithValue(r.Context(), proxyAddrsKey{}, proxyAddrs{client: client, local: local})
+	return r.WithContext(ctx)
+}
+
+// EnableProxyProtocol makes the pool send a PROXY header of the given version on
+// every backend connection. Keep-alives are disabled because a pooled connection
+// would otherwise announce the client that opened it for every later request.
+func (s *ServerPool) EnableProxyProtocol(version int) {
+	transport := s.cloneTransport()
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport.DisableKeepAlives = true
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, _ := ctx.Value(proxyAddrsKey{}).(proxyAddrs)
+		if err := writeProxyHeader(conn, version, addrs.client, addrs.local); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	s.transport = transport
+	s.proxyProtocol = version
+}
@@ -0,0 +1,276 @@
+package loadbalancer
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+	"golang-load-balancer/ratelimiter"
+)
+
+var (
+	errQueueFull    = errors.New("request queue is full")
+	errQueueTimeout = errors.New("timed out waiting in the request queue")
+)
+
+// QueueConfig lets requests wait for a free backend instead of failing right away
+type QueueConfig struct {
+	Size    int                  `json:"size"`              // most requests of the route waiting at once
+	Timeout ratelimiter.Duration `json:"timeout,omitempty"` // longest wait, 1s if empty
+}
+
+func (qc *QueueConfig) timeout() time.Duration {
+	if qc.Timeout <= 0 {
+		return time.Second
+	}
+	return time.Duration(qc.Timeout)
+}
+
+// waitQueue holds requests that found no capacity, in FIFO or priority order.
+// Whenever capacity may have freed up, the request at the head is woken to
+// try again. One request tries at a time: once it gets through the new head
+// is woken, and if it finds no room the turn passes to the request behind it,
+// whose backend may still have some.
+type waitQueue struct {
+	priority bool // order by priority first, then arrival
+	waiters  waiterHeap
+	turn     *waiter // the request trying right now, nil if none
+	rewake   bool    // capacity may have freed up during the turn, start again at the head
+	seq      uint64
+	depth    map[string]int // waiting requests per route
+	stats    queueStats
+	clock    clock.Clock
+	mutex    sync.Mutex
+}
+
+type waiter struct {
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+	index    int // position in the heap, -1 once removed
+}
+
+type queueStats struct {
+	Queued    uint64        `json:"queued"`
+	Admitted  uint64        `json:"admitted"`
+	Rejected  uint64        `json:"rejected"` // queue was full
+	TimedOut  uint64        `json:"timed_out"`
+	Cancelled uint64        `json:"cancelled"` // client went away while waiting
+	totalWait time.Duration // of admitted requests
+	maxWait   time.Duration
+}
+
+func newWaitQueue(clk clock.Clock) *waitQueue {
+	return &waitQueue{depth: make(map[string]int), clock: clk}
+}
+
+// SetOrder picks how waiting requests are served: "fifo" or "priority"
+func (q *waitQueue) SetOrder(order string) error {
+	switch order {
+	case "", "fifo":
+		q.priority = false
+	case "priority":
+		q.priority = true
+	default:
+		return errors.New("unknown queue order " + strconv.Quote(order) + ", use fifo or priority")
+	}
+	return nil
+}
+
+// wait queues the request until try succeeds, the queue timeout passes or the client goes away
+func (q *waitQueue) wait(ctx context.Context, route string, cfg *QueueConfig, priority Priority, try func() error) error {
+	q.mutex.Lock()
+	if q.depth[route] >= cfg.Size {
+		q.stats.Rejected++
+		q.mutex.Unlock()
+		return errQueueFull
+	}
+	if !q.priority {
+		priority = priorityUnset
+	}
+	q.seq++
+	w := &waiter{priority: priority, seq: q.seq, ready: make(chan struct{}, 1)}
+	heap.Push(&q.waiters, w)
+	q.depth[route]++
+	q.stats.Queued++
+	q.mutex.Unlock()
+
+	start := q.clock.Now()
+	err := q.waitTurn(ctx, w, cfg.timeout(), try)
+
+	q.mutex.Lock()
+	if w.index >= 0 {
+		heap.Remove(&q.waiters, w.index)
+	}
+	if q.turn == w {
+		q.turn, q.rewake = nil, false
+	}
+	q.depth[route]--
+	if q.depth[route] == 0 {
+		delete(q.depth, route)
+	}
+	switch {
+	case err == nil:
+		waited := q.clock.Now().Sub(start)
+		q.stats.Admitted++
+		q.stats.totalWait += waited
+		q.stats.maxWait = max(q.stats.maxWait, waited)
+	case err == errQueueTimeout:
+		q.stats.TimedOut++
+	default:
+		q.stats.Cancelled++
+	}
+	q.mutex.Unlock()
+
+	q.wake() // let the next request try
+	return err
+}
+
+func (q *waitQueue) waitTurn(ctx context.Context, w *waiter, timeout time.Duration, try func() error) error {
+	expired := q.clock.After(timeout)
+
+	q.wake() // capacity may have freed up since the caller's own attempt
+	for {
+		select {
+		case <-w.ready:
+			if try() == nil {
+				return nil
+			}
+			q.passTurn(w)
+		case <-expired:
+			return errQueueTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Len returns the number of waiting requests
+func (q *waitQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.waiters)
+}
+
+// wake lets the request at the head of the queue try again
+func (q *waitQueue) wake() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.turn != nil {
+		q.rewake = true // the head tries again when the current turn ends
+		return
+	}
+	if len(q.waiters) > 0 {
+		q.give(q.waiters[0])
+	}
+}
+
+// passTurn hands the turn of w, which found no room, to the request behind it
+func (q *waitQueue) passTurn(w *waiter) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.turn != w {
+		return
+	}
+	if q.rewake {
+		q.rewake = false
+		q.give(q.waiters[0])
+		return
+	}
+	var next *waiter
+	for _, other := range q.waiters {
+		if q.waiters.before(w, other) && (next == nil || q.waiters.before(other, next)) {
+			next = other
+		}
+	}
+	q.give(next)
+}
+
+// give makes w the request trying, must be called with the lock held
+func (q *waitQueue) give(w *waiter) {
+	q.turn = w
+	if w == nil {
+		return
+	}
+	select {
+	case w.ready <- struct{}{}:
+	default: // already woken
+	}
+}
+
+// ServeHTTP reports the queue depth and wait times as JSON
+func (q *waitQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.mutex.Lock()
+	depth := make(map[string]int, len(q.depth))
+	for route, n := range q.depth {
+		depth[route] = n
+	}
+	stats := q.stats
+	total := len(q.waiters)
+	q.mutex.Unlock()
+
+	var avgWait time.Duration
+	if stats.Admitted > 0 {
+		avgWait = stats.totalWait / time.Duration(stats.Admitted)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Depth      int            `json:"depth"`
+		RouteDepth map[string]int `json:"route_depth"`
+		queueStats
+		AvgWaitMs float64 `json:"avg_wait_ms"`
+		MaxWaitMs float64 `json:"max_wait_ms"`
+	}{
+		Depth:      total,
+		RouteDepth: depth,
+		queueStats: stats,
+		AvgWaitMs:  float64(avgWait) / float64(time.Millisecond),
+		MaxWaitMs:  float64(stats.maxWait) / float64(time.Millisecond),
+	})
+}
+
+// waiterHeap orders waiters by priority (highest first), then by arrival
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	return h.before(h[i], h[j])
+}
+
+func (waiterHeap) before(a, b *waiter) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,223 @@
+package loadbalancer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+	"golang-load-balancer/ratelimiter"
+)
+
+// slots is capacity that queued requests compete for
+type slots struct {
+	free  int
+	mutex sync.Mutex
+}
+
+func (s *slots) try() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.free == 0 {
+		return errOverloaded
+	}
+	s.free--
+	return nil
+}
+
+func (s *slots) add(q *waitQueue) {
+	s.mutex.Lock()
+	s.free++
+	s.mutex.Unlock()
+	q.wake()
+}
+
+// waitUntil polls cond, failing the test after a second
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// settled reports whether no queued request is trying right now, so capacity
+// added next goes to the head
+func settled(q *waitQueue) func() bool {
+	return func() bool {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		return q.turn == nil
+	}
+}
+
+func TestWaitQueueOrder(t *testing.T) {
+	arrivals := []Priority{PriorityLow, PriorityCritical, PriorityNormal, PriorityCritical, PriorityHigh}
+
+	tests := []struct {
+		order string
+		want  []int // indexes into arrivals, in the order they are admitted
+	}{
+		{"fifo", []int{0, 1, 2, 3, 4}},
+		{"priority", []int{1, 3, 4, 2, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			q := newWaitQueue(clock.Real)
+			if err := q.SetOrder(tt.order); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &QueueConfig{Size: 10, Timeout: ratelimiter.Duration(5 * time.Second)}
+			capacity := &slots{}
+
+			admitted := make(chan int, len(arrivals))
+			for i, p := range arrivals {
+				go func() {
+					if err := q.wait(context.Background(), "/api", cfg, p, capacity.try); err == nil {
+						admitted <- i
+					}
+				}()
+				waitUntil(t, "the request is queued", func() bool { return q.Len() == i+1 })
+			}
+
+			for _, want := range tt.want {
+				waitUntil(t, "the queue settles", settled(q))
+				capacity.add(q)
+				if got := <-admitted; got != want {
+					t.Fatalf("admitted request %d (%s), want %d (%s)", got, arrivals[got], want, arrivals[want])
+				}
+			}
+		})
+	}
+}
+
+func TestWaitQueueTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := newWaitQueue(clk)
+	capacity := &slots{}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- q.wait(context.Background(), "/api", &QueueConfig{Size: 1, Timeout: ratelimiter.Duration(2 * time.Second)}, PriorityNormal, capacity.try)
+	}()
+	waitUntil(t, "the request waits on the clock", func() bool { return clk.Waiters() == 1 })
+
+	clk.Advance(1999 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned %v before the timeout", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clk.Advance(time.Millisecond)
+	if err := <-done; err != errQueueTimeout {
+		t.Errorf("wait = %v, want %v", err, errQueueTimeout)
+	}
+	if q.Len() != 0 || q.stats.TimedOut != 1 {
+		t.Errorf("queue holds %d requests with %d timeouts, want 0 and 1", q.Len(), q.stats.TimedOut)
+	}
+}
+
+func TestWaitQueueCancel(t *testing.T) {
+	q := newWaitQueue(clock.Real)
+	capacity := &slots{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- q.wait(ctx, "/api", &QueueConfig{Size: 1, Timeout: ratelimiter.Duration(5 * time.Second)}, PriorityNormal, capacity.try)
+	}()
+	waitUntil(t, "the request is queued", func() bool { return q.Len() == 1 })
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("wait = %v, want %v", err, context.Canceled)
+	}
+	if q.Len() != 0 || q.stats.Cancelled != 1 {
+		t.Errorf("queue holds %d requests with %d cancellations, want 0 and 1", q.Len(), q.stats.Cancelled)
+	}
+
+	// the slot the cancelled request would have taken goes to the next one
+	capacity.add(q)
+	if err := q.wait(context.Background(), "/api", &QueueConfig{Size: 1}, PriorityNormal, capacity.try); err != nil {
+		t.Errorf("next request: %v", err)
+	}
+}
+
+func TestWaitQueueFull(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := newWaitQueue(clk)
+	capacity := &slots{}
+	cfg := &QueueConfig{Size: 1, Timeout: ratelimiter.Duration(1 * time.Second)}
+
+	done := make(chan error, 1)
+	go func() { done <- q.wait(context.Background(), "/api", cfg, PriorityNormal, capacity.try) }()
+	waitUntil(t, "the request is queued", func() bool { return q.Len() == 1 })
+
+	if err := q.wait(context.Background(), "/api", cfg, PriorityNormal, capacity.try); err != errQueueFull {
+		t.Errorf("second request = %v, want %v", err, errQueueFull)
+	}
+
+	// the size is per route
+	go func() { q.wait(context.Background(), "/other", cfg, PriorityNormal, capacity.try) }()
+	waitUntil(t, "the other route's request is queued", func() bool { return q.Len() == 2 })
+
+	// admitted requests report how long they waited
+	clk.Advance(300 * time.Millisecond)
+	capacity.add(q)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if q.stats.maxWait != 300*time.Millisecond || q.stats.Rejected != 1 {
+		t.Errorf("max wait %v with %d rejections, want 300ms and 1", q.stats.maxWait, q.stats.Rejected)
+	}
+	clk.Advance(time.Second) // let the other route time out
+}
+
+func TestWaitQueueSetOrder(t *testing.T) {
+	if err := newWaitQueue(clock.Real).SetOrder("lifo"); err == nil {
+		t.Error("unknown order accepted")
+	}
+}
+
+func TestWaitQueuePassesTheTurnOn(t *testing.T) {
+	q := newWaitQueue(clock.Real)
+	cfg := &QueueConfig{Size: 10, Timeout: ratelimiter.Duration(5 * time.Second)}
+	capacity := &slots{}
+
+	// the head waits for a backend that stays full, e.g. one its client is pinned to
+	ctx, cancel := context.WithCancel(context.Background())
+	pinned := make(chan error, 1)
+	go func() {
+		pinned <- q.wait(ctx, "/api", cfg, PriorityNormal, func() error { return errOverloaded })
+	}()
+	waitUntil(t, "the first request is queued", func() bool { return q.Len() == 1 })
+	admitted := make(chan error, 1)
+	go func() {
+		admitted <- q.wait(context.Background(), "/api", cfg, PriorityNormal, capacity.try)
+	}()
+	waitUntil(t, "the second request is queued", func() bool { return q.Len() == 2 })
+	waitUntil(t, "the queue settles", settled(q))
+
+	capacity.add(q)
+	select {
+	case err := <-admitted:
+		if err != nil {
+			t.Fatalf("request behind the head = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request behind a head that found no room was not tried")
+	}
+	if q.Len() != 1 {
+		t.Errorf("%d requests queued, want the pinned one", q.Len())
+	}
+	cancel()
+	if err := <-pinned; err != context.Canceled {
+		t.Errorf("pinned request = %v, want %v", err, context.Canceled)
+	}
+}
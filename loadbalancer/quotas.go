@@ -0,0 +1,44 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang-load-balancer/quota"
+)
+
+// quotaUsage reports a client's usage of every quota, e.g. GET /admin/quota?key=203.0.113.7
+func quotaUsage(w http.ResponseWriter, r *http.Request, quotas *quota.Manager) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Key    string         `json:"key"`
+		Quotas []quota.Status `json:"quotas"`
+	}{key, quotas.Usage(key)})
+}
+
+// resetQuota clears a client's usage, of one quota if the quota parameter is given
+func resetQuota(w http.ResponseWriter, r *http.Request, quotas *quota.Manager) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("quota")
+	if err := quotas.Reset(key, name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := quotas.Save(); err != nil {
+		http.Error(w, fmt.Sprintf("Quota reset but not saved: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Quota usage of %s reset", key)
+}
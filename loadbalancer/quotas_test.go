@@ -0,0 +1,76 @@
+package loadbalancer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang-load-balancer/algorithms"
+	"golang-load-balancer/clientip"
+	"golang-load-balancer/quota"
+)
+
+func TestQuotaRefundedWhenNotServed(t *testing.T) {
+	pool := NewServerPool(algorithms.RoundRobinStrategy)
+	pool.InitStrategy(algorithms.RoundRobinStrategy)
+	b, _ := pool.AddBackendDynamic("http://localhost:8081", 1)
+	b.SetAlive(false)
+
+	resolver, _ := clientip.NewResolver(nil)
+	quotas, err := quota.NewManager([]quota.Quota{{Name: "daily", Limit: 10, Period: quota.Daily}},
+		func(r *http.Request) string { return "client" }, time.UTC, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ProxyConfig{ClientIP: resolver, Quotas: quotas}
+	limits, _ := newConcurrencyLimits(cfg.Concurrency, nil)
+	shedder := &loadShedder{limits: limits, queue: pool.waiters}
+	handler := proxyHandler(pool, &Route{Path: "/", Cost: 3}, nil, limits, shedder, cfg)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if used := quotas.Usage("client")[0].Used; used != 0 {
+		t.Errorf("used = %d after the request was turned away, want 0", used)
+	}
+}
+
+func TestStartProxySavesQuotasOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	newQuotas := func() *quota.Manager {
+		t.Helper()
+		m, err := quota.NewManager([]quota.Quota{{Name: "daily", Limit: 10, Period: quota.Daily}},
+			func(r *http.Request) string { return "client" }, time.UTC, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	quotas := newQuotas()
+	quotas.Allow(httptest.NewRequest(http.MethodGet, "/", nil), 3)
+
+	pool := NewServerPool(algorithms.RoundRobinStrategy)
+	pool.InitStrategy(algorithms.RoundRobinStrategy)
+	resolver, _ := clientip.NewResolver(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		StartProxy(ctx, pool, ProxyConfig{Addr: "127.0.0.1:0", Routes: DefaultRoutes(), ClientIP: resolver, Quotas: quotas})
+		close(stopped)
+	}()
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartProxy did not return after its context was canceled")
+	}
+
+	if used := newQuotas().Usage("client")[0].Used; used != 3 {
+		t.Errorf("used = %d after reloading the saved quotas, want 3", used)
+	}
+}
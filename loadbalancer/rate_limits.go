@@ -0,0 +1,113 @@
+package loadbalancer
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang-load-balancer/quota"
+	"golang-load-balancer/ratelimiter"
+)
+
+// compileRules compiles rules with the settings of cfg. scope keeps shared
+// counters of route rules apart from equally named rules of other routes.
+func compileRules(rules []ratelimiter.Rule, scope string, cfg *ProxyConfig) ([]*ratelimiter.CompiledRule, error) {
+	opts := ratelimiter.CompileOptions{
+		ClientIP:   cfg.ClientIP.ClientIP,
+		Counters:   cfg.Counters,
+		Scope:      scope,
+		MaxClients: cfg.MaxClients,
+		ClientTTL:  cfg.ClientTTL,
+		Clock:      cfg.clock,
+	}
+
+	var compiled []*ratelimiter.CompiledRule
+	for _, rule := range rules {
+		cr, err := ratelimiter.CompileRule(rule, opts)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, cr)
+	}
+	return compiled, nil
+}
+
+// routePolicy combines the rules that apply to a route: the global rules shared
+// by every route, followed by the route's own rules. Global rules without a key
+// limit per client; on routes with a rate_limit_key they use that key instead,
+// prefixed with the route path so those buckets are not shared with other routes.
+func routePolicy(route *Route, globalRules []*ratelimiter.CompiledRule, cfg *ProxyConfig) (*ratelimiter.Policy, error) {
+	var rules []*ratelimiter.CompiledRule
+
+	for _, rule := range globalRules {
+		if rule.Key != "" || route.RateLimitKey == "" {
+			rules = append(rules, rule)
+			continue
+		}
+
+		routeKey, err := ratelimiter.ParseKey(route.RateLimitKey, cfg.ClientIP.ClientIP)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule.WithKey(func(r *http.Request) string {
+			return route.Path + "|" + routeKey(r)
+		}))
+	}
+
+	routeRules, err := compileRules(route.RateLimits, route.Path+"|", cfg)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimiter.NewPolicy(append(rules, routeRules...)...), nil
+}
+
+// chargeReportedCost charges the cost a backend reported in the route's cost
+// header, beyond what the request already took. The header is not passed on.
+// Negative and non-numeric costs are ignored; huge ones are capped by the
+// limiters and quotas, so a backend cannot lock a client out for long.
+func chargeReportedCost(resp *http.Response, r *http.Request, route *Route, policy *ratelimiter.Policy, quotas *quota.Manager) {
+	if route.CostHeader == "" {
+		return
+	}
+	value := resp.Header.Get(route.CostHeader)
+	resp.Header.Del(route.CostHeader)
+	if value == "" {
+		return
+	}
+
+	reported, err := strconv.Atoi(value)
+	if err != nil || reported < 0 {
+		log.Printf("Invalid %s %q from %s", route.CostHeader, value, resp.Request.URL.Host)
+		return
+	}
+	if extra := reported - route.cost(); extra > 0 {
+		policy.Charge(r, extra)
+		if quotas != nil {
+			quotas.Charge(r, extra)
+		}
+	}
+}
+
+// startPeerSync serves the counts pushed by other instances on addr in the background
+func startPeerSync(addr string, peers *ratelimiter.PeerCounterStore) (*http.Server, error) {
+	if addr == "" {
+		return nil, errors.New("peer counters need a listener address")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(ratelimiter.PeerSyncPath, peers)
+	server := &http.Server{Handler: mux}
+	go func() {
+		log.Printf("Receiving rate limit counts from peers on %s", addr)
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	return server, nil
+}
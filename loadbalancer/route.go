@@ -0,0 +1,87 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang-load-balancer/ratelimiter"
+)
+
+// Route describes a path served by the proxy and the policies applied to it.
+// gRPC routes match on the :path of the call, e.g. "/helloworld.Greeter/" for a
+// whole service or "/helloworld.Greeter/SayHello" for one method.
+type Route struct {
+	Path    string        `json:"path"`
+	Headers *HeaderPolicy `json:"headers,omitempty"`
+	GRPC    bool          `json:"grpc,omitempty"` // report errors as gRPC statuses
+
+	// RateLimitKey picks what requests are limited by, see ratelimiter.ParseKey.
+	// Empty means per client IP, shared with the other routes.
+	RateLimitKey string `json:"rate_limit_key,omitempty"`
+
+	// RateLimits are evaluated on top of the global rules, each with its own buckets
+	RateLimits []ratelimiter.Rule `json:"rate_limits,omitempty"`
+
+	// Cost is how many units of every rate limit a request takes, 1 if unset.
+	// CostHeader names a response header in which the backend reports the real
+	// cost; anything above Cost is charged after the response.
+	Cost       int    `json:"cost,omitempty"`
+	CostHeader string `json:"cost_header,omitempty"`
+
+	// Queue lets requests wait for a free backend, overriding the default queue
+	Queue *QueueConfig `json:"queue,omitempty"`
+
+	// Priority is the class of the route's requests, unless a trusted header or client tier says otherwise
+	Priority Priority `json:"priority,omitempty"`
+}
+
+// cost returns the units a request on the route takes before it is proxied
+func (route *Route) cost() int {
+	if route.Cost > 0 {
+		return route.Cost
+	}
+	return 1
+}
+
+// DefaultRoutes is used when no routes file is given
+func DefaultRoutes() []*Route {
+	return []*Route{{Path: "/loadbalancer"}}
+}
+
+// LoadRoutes reads a JSON array of routes from the given file
+func LoadRoutes(path string) ([]*Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []*Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parsing routes file %s: %v", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if route.Path == "" {
+			return nil, fmt.Errorf("route without path in %s", path)
+		}
+		if seen[route.Path] {
+			return nil, fmt.Errorf("duplicate route %s in %s", route.Path, path)
+		}
+		seen[route.Path] = true
+
+		if _, err := ratelimiter.ParseKey(route.RateLimitKey, nil); err != nil {
+			return nil, fmt.Errorf("route %s in %s: %v", route.Path, path, err)
+		}
+		if route.Cost < 0 {
+			return nil, fmt.Errorf("route %s in %s: cost must not be negative", route.Path, path)
+		}
+		for _, rule := range route.RateLimits {
+			if err := rule.Validate(); err != nil {
+				return nil, fmt.Errorf("route %s in %s: %v", route.Path, path, err)
+			}
+		}
+	}
+	return routes, nil
+}
@@ -1,37 +1,155 @@
 package loadbalancer
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"strconv"
 	"sync"
 
 	"golang-load-balancer/algorithms"
 	"golang-load-balancer/backend"
+	"golang-load-balancer/clock"
 )
 
+// errBackendExists is returned when a backend with the same host:port is already in the pool
+var errBackendExists = errors.New("backend already exists")
+
 type ServerPool struct {
-	backends []*backend.Backend
-	strategy algorithms.Strategy
-	mutex    sync.Mutex
+	backends  []*backend.Backend
+	strategy  algorithms.Strategy
+	transport http.RoundTripper // used for proxying and health checks, nil means http.DefaultTransport
+	mutex     sync.Mutex
+
+	proxyProtocol int // PROXY protocol version sent to backends, 0 if disabled
+	tunnels       *tunnelRegistry
+	waiters       *waitQueue // requests waiting for a free backend
+	clock         clock.Clock
+	onRemove      func(b *backend.Backend) // cleans up state kept for a removed backend, may be nil
+
+	grpcHealth        bool   // check backends with the gRPC health protocol instead of GET /health
+	grpcHealthService string // service name sent in gRPC health checks, empty for the whole server
 }
 
 func NewServerPool(strategyType algorithms.StrategyType) *ServerPool {
 	return &ServerPool{
 		backends: []*backend.Backend{},
 		strategy: nil,
+		tunnels:  newTunnelRegistry(),
+		waiters:  newWaitQueue(clock.Real),
+		clock:    clock.Real,
 	}
 }
 
+// SetClock replaces the clock used by health checks, queue timeouts and rate limits
+func (s *ServerPool) SetClock(c clock.Clock) {
+	s.clock = clock.OrReal(c)
+	s.waiters.clock = s.clock
+}
+
 func (s *ServerPool) InitStrategy(strategyType algorithms.StrategyType) {
 	s.strategy = algorithms.NewStrategy(strategyType, s.backends)
 }
 
+// SetUpstreamTLS makes the pool reach its backends with the given TLS settings
+func (s *ServerPool) SetUpstreamTLS(cfg *UpstreamTLSConfig) error {
+	tlsConfig, err := newUpstreamTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	transport := s.cloneTransport()
+	transport.TLSClientConfig = tlsConfig
+	s.transport = transport
+	return nil
+}
+
+// SetUpstreamProtocol picks the HTTP version spoken to backends: "http1",
+// "h2" (HTTP/2 over TLS) or "h2c" (HTTP/2 with prior knowledge over cleartext).
+// An empty proto keeps the transport's defaults, which negotiate HTTP/2 through
+// ALPN with https backends and use HTTP/1.1 otherwise.
+func (s *ServerPool) SetUpstreamProtocol(proto string) error {
+	protocols := new(http.Protocols)
+	switch proto {
+	case "":
+		return nil
+	case "http1":
+		protocols.SetHTTP1(true)
+	case "h2":
+		protocols.SetHTTP2(true)
+	case "h2c":
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return fmt.Errorf("unknown upstream protocol %q, use one of: http1, h2, h2c", proto)
+	}
+
+	transport := s.cloneTransport()
+	transport.Protocols = protocols
+	transport.ForceAttemptHTTP2 = proto == "h2"
+	if proto == "http1" && transport.TLSClientConfig != nil {
+		// cloning a transport sets up HTTP/2 on it first, so the ALPN list already
+		// offers h2, which a backend would pick even though only HTTP/1.1 is spoken
+		var nextProtos []string
+		for _, p := range transport.TLSClientConfig.NextProtos {
+			if p != "h2" {
+				nextProtos = append(nextProtos, p)
+			}
+		}
+		transport.TLSClientConfig.NextProtos = nextProtos
+	}
+	s.transport = transport
+	return nil
+}
+
+// cloneTransport copies the pool's transport so settings can be layered on top of each other
+func (s *ServerPool) cloneTransport() *http.Transport {
+	if t, ok := s.transport.(*http.Transport); ok {
+		return t.Clone()
+	}
+	return http.DefaultTransport.(*http.Transport).Clone()
+}
+
+// SetGRPCHealthCheck makes the health checker use grpc.health.v1.Health/Check
+func (s *ServerPool) SetGRPCHealthCheck(service string) {
+	s.grpcHealth = true
+	s.grpcHealthService = service
+}
+
+// Transport returns the round tripper used to reach the pool's backends
+func (s *ServerPool) Transport() http.RoundTripper {
+	if s.transport == nil {
+		return http.DefaultTransport
+	}
+	return s.transport
+}
+
 func (s *ServerPool) GetBackends() []*backend.Backend {
 	return s.backends
 }
 
+// snapshot copies the backend list so it can be read while backends are added or removed
+func (s *ServerPool) snapshot() []*backend.Backend {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*backend.Backend(nil), s.backends...)
+}
+
+// others returns the backends after b in pool order, wrapping around, without b itself
+func (s *ServerPool) others(b *backend.Backend) []*backend.Backend {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, candidate := range s.backends {
+		if candidate == b {
+			return append(append([]*backend.Backend(nil), s.backends[i+1:]...), s.backends[:i]...)
+		}
+	}
+	return append([]*backend.Backend(nil), s.backends...)
+}
+
+// GetNextBackend picks a backend and counts the connection against it,
+// callers hand it back with releaseBackend when they are done
 func (s *ServerPool) GetNextBackend() *backend.Backend {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -39,7 +157,49 @@ func (s *ServerPool) GetNextBackend() *backend.Backend {
 	if s.strategy == nil {
 		return nil
 	}
-	return s.strategy.GetNextBackend()
+	return acquire(s.strategy.GetNextBackend())
+}
+
+// GetNextBackendFor picks a backend for the given client IP, which keyed strategies
+// like IP hashing use to keep a client on the same backend
+func (s *ServerPool) GetNextBackendFor(clientIP string) *backend.Backend {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.strategy == nil {
+		return nil
+	}
+	if keyed, ok := s.strategy.(algorithms.KeyedStrategy); ok {
+		return acquire(keyed.GetNextBackendForKey(clientIP))
+	}
+	return acquire(s.strategy.GetNextBackend())
+}
+
+// keyed reports whether the strategy picks backends by client key
+func (s *ServerPool) keyed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.strategy.(algorithms.KeyedStrategy)
+	return ok
+}
+
+// acquire counts a new connection on b while the pool lock is still held,
+// so LeastConnections sees it on the very next pick
+func acquire(b *backend.Backend) *backend.Backend {
+	if b != nil {
+		b.IncrementConnections()
+	}
+	return b
+}
+
+// releaseBackend gives back the connection counted when b was picked
+func (s *ServerPool) releaseBackend(b *backend.Backend) {
+	b.DecrementConnections()
+}
+
+// notifyCapacity wakes queued requests after a backend or slot may have freed up
+func (s *ServerPool) notifyCapacity() {
+	s.waiters.wake()
 }
 
 func (s *ServerPool) GetStrategyType() algorithms.StrategyType {
@@ -78,6 +238,13 @@ func (s *ServerPool) AddBackendDynamic(backendURL string, weight int) (*backend.
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	// checked under the lock, so concurrent adds of the same host cannot both succeed
+	for _, b := range s.backends {
+		if b.URL.Host == parsedURL.Host {
+			return nil, fmt.Errorf("%w: %s", errBackendExists, parsedURL.Host)
+		}
+	}
+
 	b := &backend.Backend{
 		URL:               parsedURL,
 		Alive:             true,
@@ -91,11 +258,46 @@ func (s *ServerPool) AddBackendDynamic(backendURL string, weight int) (*backend.
 	if s.strategy != nil {
 		s.strategy.UpdateBackends(s.backends)
 	}
+	s.waiters.wake()
 
 	log.Printf("Dynamically added backend: %s", backendURL)
 	return b, nil
 }
 
+// FindBackend returns the backend with the given URL, or nil
+func (s *ServerPool) FindBackend(backendURL string) *backend.Backend {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, b := range s.backends {
+		if b.URL.String() == backendURL {
+			return b
+		}
+	}
+	return nil
+}
+
+// SetWeight changes the weight of b. Backends are picked with the pool lock
+// held, so the strategy never sees a half-updated weight.
+func (s *ServerPool) SetWeight(b *backend.Backend, weight int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b.Weight = weight
+	b.CurrentWeight = 0
+
+	// strategies like consistent hashing derive their state from the weights
+	if s.strategy != nil {
+		s.strategy.UpdateBackends(s.backends)
+	}
+}
+
+// Weight returns the weight of b
+func (s *ServerPool) Weight(b *backend.Backend) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return b.Weight
+}
+
 // Dynamic RemoveBackend at runtime
 func (s *ServerPool) RemoveBackendDynamic(backendURL string) error {
 	s.mutex.Lock()
@@ -104,6 +306,9 @@ func (s *ServerPool) RemoveBackendDynamic(backendURL string) error {
 	for i, b := range s.backends {
 		if b.URL.String() == backendURL {
 			s.backends = append(s.backends[:i], s.backends[i+1:]...)
+			if s.onRemove != nil {
+				s.onRemove(b)
+			}
 
 			// Important: Reinitialize strategy because number of backends changed
 			if s.strategy != nil {
@@ -0,0 +1,57 @@
+package loadbalancer
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestUpstreamProtocolWithTLSBackend(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", "Test CA", nil, nil, true)
+	cert := newTestCert(t, dir, "backend", "localhost", []string{"localhost"}, ca, false)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a backend offering both h2 and http/1.1 through ALPN
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	})}
+	go server.ServeTLS(listener, cert.certFile, cert.keyFile)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	tests := []struct {
+		proto string
+		want  string
+	}{
+		{"", "HTTP/2.0"}, // negotiated through ALPN
+		{"http1", "HTTP/1.1"},
+		{"h2", "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		pool := NewServerPool("round_robin")
+		if err := pool.SetUpstreamTLS(&UpstreamTLSConfig{CAFile: ca.certFile}); err != nil {
+			t.Fatal(err)
+		}
+		if err := pool.SetUpstreamProtocol(tt.proto); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := (&http.Client{Transport: pool.Transport()}).Get("https://localhost:" + port)
+		if err != nil {
+			t.Fatalf("proto %q: %v", tt.proto, err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Proto"); got != tt.want {
+			t.Errorf("proto %q: backend saw %s, want %s", tt.proto, got, tt.want)
+		}
+	}
+
+	if err := NewServerPool("round_robin").SetUpstreamProtocol("spdy"); err == nil {
+		t.Error("unknown protocol accepted")
+	}
+}
@@ -0,0 +1,189 @@
+package loadbalancer
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang-load-balancer/backend"
+	"golang-load-balancer/clientip"
+	"golang-load-balancer/proxyproto"
+)
+
+// TCPConfig holds the settings of the layer-4 TCP proxy
+type TCPConfig struct {
+	Addr        string
+	IdleTimeout time.Duration // close connections with no traffic in either direction for this long, 0 disables
+	DialTimeout time.Duration
+
+	ProxyProtocol     bool               // accept PROXY protocol headers from trusted peers
+	SendProxyProtocol int                // PROXY protocol version sent to backends, 0 disables
+	Trusted           *clientip.Resolver // peers allowed to send PROXY headers
+}
+
+// StartTCPProxy accepts TCP connections and splices each one to a backend picked by the pool.
+// Backends are given as tcp://host:port URLs.
+func StartTCPProxy(pool *ServerPool, cfg TCPConfig) {
+	listener, err := listen(cfg.Addr, cfg.ProxyProtocol, cfg.Trusted)
+	if err != nil {
+		log.Fatalf("TCP listen on %s failed: %v", cfg.Addr, err)
+	}
+	log.Printf("Starting TCP Load Balancer on %s", cfg.Addr)
+	serveTCP(listener, pool, cfg)
+}
+
+func serveTCP(listener net.Listener, pool *ServerPool, cfg TCPConfig) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("TCP accept error: %v", err)
+			pool.clock.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go handleTCPConn(conn, pool, cfg)
+	}
+}
+
+func handleTCPConn(client net.Conn, pool *ServerPool, cfg TCPConfig) {
+	defer client.Close()
+
+	// Asking a PROXY connection for its address waits for the header. Only do
+	// that up front when the address picks the backend or is announced to it;
+	// otherwise the header is parsed on the first read, so server-speaks-first
+	// protocols are not held up by trusted peers that send no header.
+	var b *backend.Backend
+	var clientIP string
+	if pool.keyed() || cfg.SendProxyProtocol != 0 {
+		clientIP = clientip.StripPort(client.RemoteAddr().String())
+		b = pool.GetNextBackendFor(clientIP)
+	} else {
+		clientIP = peerAddr(client)
+		b = pool.GetNextBackend()
+	}
+	if b == nil {
+		log.Printf("No healthy backends available for TCP client %s", clientIP)
+		return
+	}
+	defer pool.releaseBackend(b)
+
+	upstream, err := net.DialTimeout("tcp", b.URL.Host, cfg.DialTimeout)
+	if err != nil {
+		log.Printf("TCP dial to %s failed: %v", b.URL.Host, err)
+		return
+	}
+	defer upstream.Close()
+
+	if cfg.SendProxyProtocol != 0 {
+		if err := writeProxyHeader(upstream, cfg.SendProxyProtocol, client.RemoteAddr(), client.LocalAddr()); err != nil {
+			log.Printf("Sending PROXY header to %s failed: %v", b.URL.Host, err)
+			return
+		}
+	}
+
+	log.Printf("Forwarding TCP connection from %s to %s", clientIP, b.URL.Host)
+	splice(client, upstream, cfg.IdleTimeout)
+}
+
+// peerAddr is the address of the directly connected peer, without waiting for a PROXY header
+func peerAddr(conn net.Conn) string {
+	if pc, ok := conn.(*proxyproto.Conn); ok {
+		conn = pc.Conn
+	}
+	return clientip.StripPort(conn.RemoteAddr().String())
+}
+
+// tcpWriteTimeout bounds writes to a peer that stopped reading when no idle timeout is set
+const tcpWriteTimeout = time.Minute
+
+// splice copies bytes both ways until both sides are done. When one side
+// finishes sending, the write half towards the other side is closed so
+// protocols relying on half-close keep working.
+func splice(client, upstream net.Conn, idleTimeout time.Duration) {
+	s := &spliceState{idleTimeout: idleTimeout}
+	s.touch()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.pipe(upstream, client)
+	}()
+	go func() {
+		defer wg.Done()
+		s.pipe(client, upstream)
+	}()
+	wg.Wait()
+}
+
+// spliceState tracks traffic in both directions. Idle checks follow the read
+// deadlines the sockets enforce, so they use the system clock like them.
+type spliceState struct {
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+}
+
+func (s *spliceState) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *spliceState) idle() bool {
+	return time.Since(time.Unix(0, s.lastActivity.Load())) >= s.idleTimeout
+}
+
+func (s *spliceState) pipe(dst, src net.Conn) {
+	writeTimeout := s.idleTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = tcpWriteTimeout
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		if s.idleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			s.touch()
+			// a peer that stops reading must not pin this goroutine forever
+			dst.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				src.Close()
+				dst.Close()
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && !s.idle() {
+			continue // the other direction is still active
+		}
+		if err == io.EOF {
+			closeWrite(dst)
+			return
+		}
+
+		// idle timeout or broken connection: tear down both directions
+		src.Close()
+		dst.Close()
+		return
+	}
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,177 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang-load-balancer/proxyproto"
+)
+
+// startTCPProxy serves a TCP proxy in front of backend on a loopback port
+func startTCPProxy(t *testing.T, backend net.Listener, idleTimeout time.Duration) string {
+	t.Helper()
+	pool := NewServerPool("round_robin")
+	pool.InitStrategy("round_robin")
+	if _, err := pool.AddBackendDynamic("tcp://"+backend.Addr().String(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveTCP(listener, pool, TCPConfig{IdleTimeout: idleTimeout, DialTimeout: time.Second})
+	return listener.Addr().String()
+}
+
+func listenLoopback(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestTCPProxyHalfClose(t *testing.T) {
+	backend := listenLoopback(t)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// answer only once the client has finished sending
+		data, _ := io.ReadAll(conn)
+		conn.Write(bytes.ToUpper(data))
+	}()
+
+	conn, err := net.Dial("tcp", startTCPProxy(t, backend, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("hello "))
+	conn.Write([]byte("world"))
+	conn.(*net.TCPConn).CloseWrite()
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "HELLO WORLD" {
+		t.Errorf("reply = %q, want HELLO WORLD", reply)
+	}
+}
+
+func TestTCPProxyIdleTimeout(t *testing.T) {
+	backend := listenLoopback(t)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn) // never says anything
+	}()
+
+	conn, err := net.Dial("tcp", startTCPProxy(t, backend, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read data from a silent backend")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("proxy did not close the idle connection")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("connection closed after %v, before the idle timeout", elapsed)
+	}
+}
+
+func TestTCPProxyStalledPeer(t *testing.T) {
+	backend := listenLoopback(t)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// keeps the connection busy but never reads what the client sends
+		for {
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", startTCPProxy(t, backend, 200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.Copy(io.Discard, conn)
+
+	// keep sending until the proxy gives up on the backend and drops us
+	chunk := make([]byte, 64*1024)
+	for {
+		if _, err := conn.Write(chunk); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("proxy kept a connection to a stalled backend open")
+			}
+			return
+		}
+	}
+}
+
+func TestTCPProxyServerSpeaksFirst(t *testing.T) {
+	backend := listenLoopback(t)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 ready\n"))
+		io.Copy(io.Discard, conn)
+	}()
+
+	pool := NewServerPool("round_robin")
+	pool.InitStrategy("round_robin")
+	if _, err := pool.AddBackendDynamic("tcp://"+backend.Addr().String(), 1); err != nil {
+		t.Fatal(err)
+	}
+	// the client is a trusted PROXY peer that sends no header and waits for the greeting
+	inner := listenLoopback(t)
+	listener := &proxyproto.Listener{Listener: inner, Trusted: func(string) bool { return true }, HeaderTimeout: 5 * time.Second}
+	go serveTCP(listener, pool, TCPConfig{DialTimeout: time.Second})
+
+	conn, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("no greeting before the header timeout: %v", err)
+	}
+	if greeting != "220 ready\n" {
+		t.Errorf("greeting = %q", greeting)
+	}
+}
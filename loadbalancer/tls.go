@@ -0,0 +1,247 @@
+package loadbalancer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clientip"
+	"golang-load-balancer/clock"
+)
+
+// TLSConfig describes the HTTPS listener of the proxy
+type TLSConfig struct {
+	Addr           string
+	Certificates   []CertificateFiles
+	MinVersion     string        // "1.0", "1.1", "1.2" or "1.3"
+	CipherSuites   []string      // names as listed by tls.CipherSuites, empty for Go defaults
+	ReloadInterval time.Duration // how often certificate files are checked for changes, 0 disables reloading
+	RedirectHTTP   bool          // redirect the plain listener to HTTPS
+
+	ClientAuth       string // "none", "request" or "require" a client certificate
+	ClientCAFile     string // PEM bundle used to verify client certificates
+	ClientCertHeader string // header carrying the verified client subject to backends, e.g. X-Client-Cert-Subject
+}
+
+// CertificateFiles is a PEM certificate chain and its private key
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// ParseCertificateFiles parses "cert.pem:key.pem" pairs
+func ParseCertificateFiles(pairs []string) ([]CertificateFiles, error) {
+	var files []CertificateFiles
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+		certFile, keyFile, ok := strings.Cut(pair, ":")
+		if !ok || certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("certificate %q must be given as cert.pem:key.pem", pair)
+		}
+		files = append(files, CertificateFiles{CertFile: certFile, KeyFile: keyFile})
+	}
+	return files, nil
+}
+
+// certStore holds the loaded certificates indexed by the names they are valid for
+type certStore struct {
+	files    []CertificateFiles
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+	modTimes map[string]time.Time
+	clock    clock.Clock
+	mutex    sync.RWMutex
+}
+
+func newCertStore(files []CertificateFiles, clk clock.Clock) (*certStore, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("at least one certificate is required")
+	}
+	cs := &certStore{files: files, clock: clock.OrReal(clk)}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// load reads every certificate from disk and swaps them in only if all succeed
+func (cs *certStore) load() error {
+	byName := make(map[string]*tls.Certificate)
+	modTimes := make(map[string]time.Time)
+	var fallback *tls.Certificate
+
+	for _, f := range cs.files {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate %s: %v", f.CertFile, err)
+		}
+		if fallback == nil {
+			fallback = &cert
+		}
+
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, exists := byName[name]; !exists {
+				byName[name] = &cert
+			}
+		}
+
+		for _, path := range []string{f.CertFile, f.KeyFile} {
+			if info, err := os.Stat(path); err == nil {
+				modTimes[path] = info.ModTime()
+			}
+		}
+	}
+
+	cs.mutex.Lock()
+	cs.byName = byName
+	cs.fallback = fallback
+	cs.modTimes = modTimes
+	cs.mutex.Unlock()
+	return nil
+}
+
+// changed reports whether any certificate or key file was modified since the last load
+func (cs *certStore) changed() bool {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	for path, modTime := range cs.modTimes {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch reloads the certificates whenever their files change on disk
+func (cs *certStore) watch(interval time.Duration) {
+	for {
+		cs.clock.Sleep(interval)
+		if !cs.changed() {
+			continue
+		}
+		if err := cs.load(); err != nil {
+			log.Printf("Certificate reload failed, keeping previous certificates: %v", err)
+			continue
+		}
+		log.Printf("Reloaded TLS certificates")
+	}
+}
+
+// getCertificate picks a certificate by SNI: exact name, then wildcard, then the first one loaded
+func (cs *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := cs.byName[name]; ok {
+		return cert, nil
+	}
+	if _, rest, ok := strings.Cut(name, "."); ok {
+		if cert, ok := cs.byName["*."+rest]; ok {
+			return cert, nil
+		}
+	}
+	return cs.fallback, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// buildTLSConfig turns the listener settings into a tls.Config backed by the store
+func buildTLSConfig(cfg *TLSConfig, store *certStore) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: store.getCertificate,
+	}
+
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q, use one of: 1.0, 1.1, 1.2, 1.3", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	for _, name := range cfg.CipherSuites {
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+
+	if err := configureClientAuth(tlsConfig, cfg); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// newTLSServer loads the certificates and builds the HTTPS server for handler
+func newTLSServer(cfg *TLSConfig, handler http.Handler, clk clock.Clock) (*http.Server, error) {
+	store, err := newCertStore(cfg.Certificates, clk)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := buildTLSConfig(cfg, store)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval > 0 {
+		go store.watch(cfg.ReloadInterval)
+	}
+
+	// HTTP/2 is negotiated through ALPN next to HTTP/1.1
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+
+	return &http.Server{
+		Addr:      cfg.Addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+		Protocols: protocols,
+	}, nil
+}
+
+// redirectToHTTPS sends plain HTTP clients to the same URL on the HTTPS listener
+func redirectToHTTPS(httpsAddr string) http.HandlerFunc {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := clientip.StripPort(r.Host)
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // bare IPv6 literal
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
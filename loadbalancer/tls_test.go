@@ -0,0 +1,185 @@
+package loadbalancer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// testCert is a generated certificate written to PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+var testSerial int64
+
+// newTestCert writes a certificate for cn and dnsNames to dir, signed by ca or
+// self-signed if ca is nil. isCA makes it usable to sign other certificates.
+func newTestCert(t *testing.T, dir, name, cn string, dnsNames []string, ca *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSerial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	os.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return tc
+}
+
+func (tc *testCert) files() CertificateFiles {
+	return CertificateFiles{CertFile: tc.certFile, KeyFile: tc.keyFile}
+}
+
+func TestGetCertificateBySNI(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, dir, "first", "first.example.com", []string{"first.example.com"}, nil, false)
+	exact := newTestCert(t, dir, "exact", "", []string{"api.example.com", "WWW.Example.com"}, nil, false)
+	wildcard := newTestCert(t, dir, "wildcard", "", []string{"*.example.org"}, nil, false)
+	cnOnly := newTestCert(t, dir, "cn", "legacy.example.net", nil, nil, false)
+
+	store, err := newCertStore([]CertificateFiles{first.files(), exact.files(), wildcard.files(), cnOnly.files()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       *testCert
+	}{
+		{"api.example.com", exact},
+		{"www.example.com", exact},
+		{"API.EXAMPLE.COM.", exact},
+		{"foo.example.org", wildcard},
+		{"example.org", first},
+		{"a.b.example.org", first},
+		{"legacy.example.net", cnOnly},
+		{"unknown.test", first},
+		{"", first},
+	}
+	for _, tt := range tests {
+		cert, err := store.getCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Leaf.Equal(tt.want.cert) {
+			t.Errorf("%q got certificate for %v, want %v", tt.serverName, cert.Leaf.DNSNames, tt.want.cert.DNSNames)
+		}
+	}
+}
+
+func TestNewCertStoreErrors(t *testing.T) {
+	if _, err := newCertStore(nil, nil); err == nil {
+		t.Error("empty certificate list accepted")
+	}
+	dir := t.TempDir()
+	if _, err := newCertStore([]CertificateFiles{{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: filepath.Join(dir, "missing-key.pem")}}, nil); err == nil {
+		t.Error("missing certificate accepted")
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	old := newTestCert(t, dir, "site", "", []string{"site.example.com"}, nil, false)
+	clk := clock.NewFake(time.Now())
+	store, err := newCertStore([]CertificateFiles{old.files()}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.changed() {
+		t.Fatal("store reports a change right after loading")
+	}
+
+	// a broken rewrite keeps the previous certificate
+	os.WriteFile(old.keyFile, []byte("garbage"), 0o600)
+	touch(t, old.keyFile)
+	if !store.changed() {
+		t.Fatal("changed key file not noticed")
+	}
+	if err := store.load(); err == nil {
+		t.Fatal("loading a broken key succeeded")
+	}
+	if cert, _ := store.getCertificate(&tls.ClientHelloInfo{}); !cert.Leaf.Equal(old.cert) {
+		t.Fatal("broken reload replaced the certificate")
+	}
+
+	renewed := newTestCert(t, dir, "site", "", []string{"site.example.com"}, nil, false)
+	touch(t, renewed.certFile)
+	touch(t, renewed.keyFile)
+	go store.watch(time.Minute)
+	for clk.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if cert, _ := store.getCertificate(&tls.ClientHelloInfo{ServerName: "site.example.com"}); cert.Leaf.Equal(renewed.cert) {
+		t.Fatal("certificate reloaded before the interval passed")
+	}
+	clk.Advance(time.Minute)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cert, _ := store.getCertificate(&tls.ClientHelloInfo{ServerName: "site.example.com"})
+		if cert.Leaf.Equal(renewed.cert) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate was not picked up")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// touch moves the modification time forward so the change is seen even on
+// file systems with coarse timestamps
+func touch(t *testing.T, path string) {
+	t.Helper()
+	future := time.Now().Add(time.Duration(testSerial) * time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package loadbalancer
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang-load-balancer/backend"
+)
+
+// tunnelConn is the client side of an upgraded connection (e.g. a WebSocket).
+// Reads wait for traffic in either direction up to the idle timeout, and a
+// draining backend can give the tunnel a deadline to finish by. Both are
+// socket deadlines, so the tunnel keeps time with the system clock.
+type tunnelConn struct {
+	net.Conn
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+	drainAt      atomic.Int64 // unix nanos, 0 if the backend is not draining
+	closeOnce    sync.Once
+	onClose      func()
+}
+
+func (c *tunnelConn) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	for {
+		deadline := time.Time{}
+		if c.idleTimeout > 0 {
+			deadline = time.Now().Add(c.idleTimeout)
+		}
+		drainAt := c.drainAt.Load()
+		if drainAt != 0 {
+			if d := time.Unix(0, drainAt); deadline.IsZero() || d.Before(deadline) {
+				deadline = d
+			}
+		}
+		c.Conn.SetReadDeadline(deadline)
+		if c.drainAt.Load() != drainAt {
+			continue // drain started while setting the deadline
+		}
+
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.touch()
+		}
+		if err != nil && errors.Is(err, os.ErrDeadlineExceeded) && !c.expired() {
+			continue // backend is still sending, the tunnel is not idle
+		}
+		return n, err
+	}
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	c.touch()
+	return c.Conn.Write(b)
+}
+
+func (c *tunnelConn) expired() bool {
+	now := time.Now()
+	if drainAt := c.drainAt.Load(); drainAt != 0 && now.UnixNano() >= drainAt {
+		return true
+	}
+	idleFor := now.Sub(time.Unix(0, c.lastActivity.Load()))
+	return c.idleTimeout > 0 && idleFor >= c.idleTimeout
+}
+
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(c.onClose)
+	return err
+}
+
+// drain makes the tunnel end once grace has passed, waking a blocked Read
+func (c *tunnelConn) drain(grace time.Duration) {
+	drainAt := time.Now().Add(grace)
+	c.drainAt.Store(drainAt.UnixNano())
+	c.Conn.SetReadDeadline(drainAt)
+}
+
+// tunnelRegistry tracks the open tunnels of every backend so they can be drained
+type tunnelRegistry struct {
+	tunnels map[*backend.Backend]map[*tunnelConn]struct{}
+	mutex   sync.Mutex
+}
+
+func newTunnelRegistry() *tunnelRegistry {
+	return &tunnelRegistry{tunnels: make(map[*backend.Backend]map[*tunnelConn]struct{})}
+}
+
+func (tr *tunnelRegistry) add(b *backend.Backend, c *tunnelConn) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	if tr.tunnels[b] == nil {
+		tr.tunnels[b] = make(map[*tunnelConn]struct{})
+	}
+	tr.tunnels[b][c] = struct{}{}
+}
+
+func (tr *tunnelRegistry) remove(b *backend.Backend, c *tunnelConn) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	delete(tr.tunnels[b], c)
+	if len(tr.tunnels[b]) == 0 {
+		delete(tr.tunnels, b)
+	}
+}
+
+func (tr *tunnelRegistry) count(b *backend.Backend) int {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	return len(tr.tunnels[b])
+}
+
+// drain gives every tunnel of b until grace has passed to finish
+func (tr *tunnelRegistry) drain(b *backend.Backend, grace time.Duration) int {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	for c := range tr.tunnels[b] {
+		c.drain(grace)
+	}
+	return len(tr.tunnels[b])
+}
+
+// DrainBackend stops sending new traffic to b and closes its open tunnels after grace
+func (s *ServerPool) DrainBackend(b *backend.Backend, grace time.Duration) {
+	b.SetDraining(true)
+	n := s.tunnels.drain(b, grace)
+	log.Printf("Draining backend %s, %d open tunnels close within %v", b.URL.String(), n, grace)
+}
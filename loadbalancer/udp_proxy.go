@@ -0,0 +1,186 @@
+package loadbalancer
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang-load-balancer/backend"
+)
+
+// UDPConfig holds the settings of the UDP proxy
+type UDPConfig struct {
+	Addr           string
+	SessionTimeout time.Duration // forget a client flow after this long without packets
+	MaxSessions    int           // most client flows open at once, each holds a socket; new ones are dropped beyond it
+}
+
+// defaultUDPSessions caps the sessions when MaxSessions is not set
+const defaultUDPSessions = 10000
+
+// udpSession is one client flow pinned to a backend. Each session has its own
+// upstream socket, so replies arriving on it belong to exactly one client.
+// Sessions expire on socket read deadlines, measured with the system clock.
+type udpSession struct {
+	client     *net.UDPAddr
+	upstream   *net.UDPConn
+	backend    *backend.Backend
+	lastActive atomic.Int64
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+type udpProxy struct {
+	listener *net.UDPConn
+	pool     *ServerPool
+	cfg      UDPConfig
+	sessions map[string]*udpSession
+	full     bool // the session cap was reached, logged once until there is room again
+	mutex    sync.Mutex
+}
+
+// StartUDPProxy forwards UDP datagrams to backends picked by the pool and routes
+// replies back to the originating client. Backends are given as udp://host:port URLs.
+func StartUDPProxy(pool *ServerPool, cfg UDPConfig) {
+	addr, err := net.ResolveUDPAddr("udp", cfg.Addr)
+	if err != nil {
+		log.Fatalf("Invalid UDP address %s: %v", cfg.Addr, err)
+	}
+	listener, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Fatalf("UDP listen on %s failed: %v", cfg.Addr, err)
+	}
+
+	if cfg.SessionTimeout <= 0 {
+		cfg.SessionTimeout = time.Minute
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = defaultUDPSessions
+	}
+
+	p := &udpProxy{
+		listener: listener,
+		pool:     pool,
+		cfg:      cfg,
+		sessions: make(map[string]*udpSession),
+	}
+	log.Printf("Starting UDP Load Balancer on %s", cfg.Addr)
+	p.serve()
+}
+
+func (p *udpProxy) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, clientAddr, err := p.listener.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("UDP read error: %v", err)
+			continue
+		}
+
+		session := p.getSession(clientAddr)
+		if session == nil {
+			continue
+		}
+		session.touch()
+		if _, err := session.upstream.Write(buf[:n]); err != nil {
+			log.Printf("UDP write to %s failed: %v", session.backend.URL.Host, err)
+		}
+	}
+}
+
+// getSession returns the flow for a client, opening one on a new backend if needed
+func (p *udpProxy) getSession(clientAddr *net.UDPAddr) *udpSession {
+	key := clientAddr.String()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if session, ok := p.sessions[key]; ok {
+		return session
+	}
+	// every session holds a socket, so a flood of spoofed sources must not open more
+	if p.cfg.MaxSessions > 0 && len(p.sessions) >= p.cfg.MaxSessions {
+		if !p.full {
+			log.Printf("UDP session limit of %d reached, dropping datagrams from new clients", p.cfg.MaxSessions)
+			p.full = true
+		}
+		return nil
+	}
+	p.full = false
+
+	b := p.pool.GetNextBackendFor(clientAddr.IP.String())
+	if b == nil {
+		log.Printf("No healthy backends available for UDP client %s", key)
+		return nil
+	}
+
+	upstream, err := dialUDP(b.URL.Host)
+	if err != nil {
+		log.Printf("UDP dial to %s failed: %v", b.URL.Host, err)
+		p.pool.releaseBackend(b)
+		return nil
+	}
+
+	session := &udpSession{client: clientAddr, upstream: upstream, backend: b}
+	session.touch()
+	p.sessions[key] = session
+	log.Printf("New UDP session %s -> %s", key, b.URL.Host)
+
+	go p.relayReplies(session)
+	return session
+}
+
+func dialUDP(host string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, addr)
+}
+
+// relayReplies sends backend replies to the session's client until the session goes idle
+func (p *udpProxy) relayReplies(session *udpSession) {
+	defer p.closeSession(session)
+
+	buf := make([]byte, 64*1024)
+	for {
+		session.upstream.SetReadDeadline(time.Now().Add(p.cfg.SessionTimeout))
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				idleFor := time.Since(time.Unix(0, session.lastActive.Load()))
+				if idleFor < p.cfg.SessionTimeout {
+					continue // client is still sending
+				}
+			}
+			return
+		}
+
+		session.touch()
+		if _, err := p.listener.WriteToUDP(buf[:n], session.client); err != nil {
+			log.Printf("UDP reply to %s failed: %v", session.client, err)
+		}
+	}
+}
+
+func (p *udpProxy) closeSession(session *udpSession) {
+	p.mutex.Lock()
+	key := session.client.String()
+	if p.sessions[key] == session {
+		delete(p.sessions, key)
+	}
+	p.mutex.Unlock()
+
+	session.upstream.Close()
+	p.pool.releaseBackend(session.backend)
+	log.Printf("Closed UDP session %s -> %s", key, session.backend.URL.Host)
+}
@@ -0,0 +1,149 @@
+package loadbalancer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPSessionExpiry(t *testing.T) {
+	// backend echoes every datagram
+	backendConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendConn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backendConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backendConn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	pool := NewServerPool("round_robin")
+	pool.InitStrategy("round_robin")
+	b, err := pool.AddBackendDynamic("udp://"+backendConn.LocalAddr().String(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	p := &udpProxy{
+		listener: listener,
+		pool:     pool,
+		cfg:      UDPConfig{SessionTimeout: 100 * time.Millisecond},
+		sessions: make(map[string]*udpSession),
+	}
+	go p.serve()
+
+	client, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 1500)
+	for _, msg := range []string{"ping", "pong"} {
+		client.Write([]byte(msg))
+		n, err := client.Read(buf)
+		if err != nil || string(buf[:n]) != msg {
+			t.Fatalf("echo of %q = %q, %v", msg, buf[:n], err)
+		}
+	}
+
+	sessions := func() int {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		return len(p.sessions)
+	}
+	if sessions() != 1 || b.GetConnections() != 1 {
+		t.Fatalf("one client flow has %d sessions and %d backend connections", sessions(), b.GetConnections())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sessions() != 0 || b.GetConnections() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle UDP session was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a new packet opens a fresh session
+	client.Write([]byte("again"))
+	if n, err := client.Read(buf); err != nil || string(buf[:n]) != "again" {
+		t.Fatalf("echo after expiry = %q, %v", buf[:n], err)
+	}
+}
+
+func TestUDPSessionLimit(t *testing.T) {
+	backendConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendConn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backendConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backendConn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	pool := NewServerPool("round_robin")
+	pool.InitStrategy("round_robin")
+	if _, err := pool.AddBackendDynamic("udp://"+backendConn.LocalAddr().String(), 1); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	p := &udpProxy{
+		listener: listener,
+		pool:     pool,
+		cfg:      UDPConfig{SessionTimeout: time.Minute, MaxSessions: 1},
+		sessions: make(map[string]*udpSession),
+	}
+	go p.serve()
+
+	buf := make([]byte, 1500)
+	first, _ := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	defer first.Close()
+	first.SetDeadline(time.Now().Add(5 * time.Second))
+	first.Write([]byte("one"))
+	if n, err := first.Read(buf); err != nil || string(buf[:n]) != "one" {
+		t.Fatalf("echo to the first client = %q, %v", buf[:n], err)
+	}
+
+	second, _ := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	second.Write([]byte("two"))
+	if n, err := second.Read(buf); err == nil {
+		t.Fatalf("client beyond the limit got %q", buf[:n])
+	}
+
+	// the open session keeps working
+	first.Write([]byte("again"))
+	if n, err := first.Read(buf); err != nil || string(buf[:n]) != "again" {
+		t.Fatalf("echo to the first client = %q, %v", buf[:n], err)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.sessions) != 1 {
+		t.Errorf("%d sessions open, want 1", len(p.sessions))
+	}
+}
@@ -0,0 +1,98 @@
+package loadbalancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// UpstreamTLSConfig describes how a pool talks to https backends
+type UpstreamTLSConfig struct {
+	CAFile             string // PEM bundle used to verify backends, system roots if empty
+	CertFile           string // client certificate for mTLS, optional
+	KeyFile            string
+	ServerName         string // overrides the SNI and the name verified on backend certificates
+	InsecureSkipVerify bool   // skip verification of the backend's identity
+}
+
+// newUpstreamTLSConfig builds the client TLS settings used to reach backends of a pool
+func newUpstreamTLSConfig(cfg *UpstreamTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading upstream client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// configureClientAuth enables client certificate verification on the listener
+func configureClientAuth(tlsConfig *tls.Config, cfg *TLSConfig) error {
+	if cfg.ClientAuth == "" || cfg.ClientAuth == "none" {
+		return nil
+	}
+
+	authType, ok := clientAuthTypes[cfg.ClientAuth]
+	if !ok {
+		return fmt.Errorf("unknown client auth %q, use one of: none, request, require", cfg.ClientAuth)
+	}
+	if cfg.ClientCAFile == "" {
+		return fmt.Errorf("client auth %q needs a client CA bundle", cfg.ClientAuth)
+	}
+
+	pool, err := loadCertPool(cfg.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig.ClientAuth = authType
+	tlsConfig.ClientCAs = pool
+	return nil
+}
+
+// setClientCertHeader forwards the verified client certificate subject to the backend.
+// Any value sent by the client is dropped so it cannot be spoofed.
+func setClientCertHeader(in *http.Request, out http.Header, name string) {
+	if name == "" {
+		return
+	}
+	out.Del(name)
+
+	if in.TLS == nil || len(in.TLS.VerifiedChains) == 0 {
+		return
+	}
+	out.Set(name, in.TLS.VerifiedChains[0][0].Subject.String())
+}
@@ -1,26 +1,107 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang-load-balancer/algorithms"
+	"golang-load-balancer/clientip"
 	"golang-load-balancer/loadbalancer"
+	"golang-load-balancer/quota"
+	"golang-load-balancer/ratelimiter"
 	"golang-load-balancer/backend"
 )
 
 func main() {
 	// CLI flags
-	algoFlag := flag.String("algo", "rr", "Load balancing strategy: rr, wrr, lc, ip")
+	modeFlag := flag.String("mode", "http", "Proxy mode: http, tcp or udp")
+	addrFlag := flag.String("addr", ":8090", "Address the load balancer listens on")
+	udpSessionsFlag := flag.Int("udp-max-sessions", 10000, "Most UDP client flows open at once, datagrams from new clients are dropped beyond it")
+	idleFlag := flag.Duration("idle-timeout", 5*time.Minute, "Close TCP connections, UDP sessions or upgraded HTTP tunnels idle for this long (0 disables for TCP and tunnels)")
+	algoFlag := flag.String("algo", "rr", "Load balancing strategy: rr, wrr, lc, ip, ch")
 	numFlag := flag.Int("n", 3, "Number of backend servers to spin up")
 	weightsFlag := flag.String("weights", "", "Comma-separated weights for each server (used with wrr)")
+	backendsFlag := flag.String("backends", "", "Comma-separated URLs of existing backends (replaces the -n dummy servers)")
 
-	limiterFlag := flag.String("limiter", "none", "Rate limiter algorithm: none, token, fixed, leaky")
-	rateFlag := flag.Int("rate", 0, "Allowed number of requests per second")
-	burstFlag := flag.Int("burst", 0, "Burst size (only for token and leaky bucket)")
+	limiterFlag := flag.String("limiter", "none", "Rate limiter algorithm: none, token, gcra, fixed, leaky, sliding-log, sliding-counter")
+	rateFlag := flag.Int("rate", 0, "Allowed number of requests per second (per window for fixed and sliding limiters)")
+	maxClientsFlag := flag.Int("max-clients", 100000, "Maximum number of clients tracked by the rate limiter")
+	clientTTLFlag := flag.Duration("client-ttl", 10*time.Minute, "Forget rate limit state of clients idle for this long")
+	windowFlag := flag.Duration("window", time.Second, "Window length for fixed and sliding window limiters")
+	globalRateFlag := flag.Int("global-rate", 0, "Requests per second allowed for the whole load balancer (0 disables)")
+	globalBurstFlag := flag.Int("global-burst", 0, "Burst size of the global token bucket")
+	burstFlag := flag.Int("burst", 0, "Burst size (only for token bucket, gcra and leaky bucket), 0 uses -rate")
+
+	counterStoreFlag := flag.String("ratelimit-store", "local", "Where rate limit counters live: local (per instance), memory, redis or peers")
+	redisAddrFlag := flag.String("redis-addr", "localhost:6379", "Redis address used by -ratelimit-store=redis")
+	redisPasswordFlag := flag.String("redis-password", "", "Redis password used by -ratelimit-store=redis")
+	peersFlag := flag.String("ratelimit-peers", "", "Comma-separated URLs of the other load balancers, used by -ratelimit-store=peers")
+	peerAddrFlag := flag.String("ratelimit-peer-addr", ":8092", "Address receiving counts from -ratelimit-peers, separate from the proxy listener")
+	slackFlag := flag.Int("ratelimit-slack", 10, "Requests counted locally before they are pushed to peers right away")
+	syncFlag := flag.Duration("ratelimit-sync", 100*time.Millisecond, "How often counts are pushed to peers")
+
+	trustedFlag := flag.String("trusted-proxies", "", "Comma-separated CIDRs of proxies whose X-Forwarded-For headers are trusted")
+	tlsAddrFlag := flag.String("tls-addr", "", "Address of the HTTPS listener, e.g. :8443 (disabled if empty)")
+	tlsCertsFlag := flag.String("tls-certs", "", "Comma-separated cert.pem:key.pem pairs, chosen by SNI")
+	tlsMinFlag := flag.String("tls-min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2, 1.3")
+	tlsCiphersFlag := flag.String("tls-ciphers", "", "Comma-separated TLS 1.2 cipher suite names (Go defaults if empty)")
+	tlsReloadFlag := flag.Duration("tls-reload", 30*time.Second, "How often to check certificate files for changes (0 disables)")
+	redirectFlag := flag.Bool("https-redirect", false, "Redirect plain HTTP requests to the HTTPS listener")
+
+	clientAuthFlag := flag.String("tls-client-auth", "none", "Client certificates on the TLS listener: none, request, require")
+	clientCAFlag := flag.String("tls-client-ca", "", "PEM bundle used to verify client certificates")
+	clientCertHeaderFlag := flag.String("tls-client-cert-header", "", "Header forwarding the verified client certificate subject to backends")
+
+	upstreamCAFlag := flag.String("upstream-ca", "", "PEM bundle used to verify https backends (system roots if empty)")
+	upstreamCertFlag := flag.String("upstream-cert", "", "Client certificate presented to backends for mTLS")
+	upstreamKeyFlag := flag.String("upstream-key", "", "Private key of the upstream client certificate")
+	upstreamSNIFlag := flag.String("upstream-server-name", "", "SNI and certificate name expected from backends")
+	upstreamInsecureFlag := flag.Bool("upstream-insecure", false, "Skip verification of backend certificates")
+
+	acceptProxyFlag := flag.Bool("accept-proxy-protocol", false, "Parse PROXY protocol v1/v2 headers from -trusted-proxies")
+	sendProxyFlag := flag.Int("send-proxy-protocol", 0, "PROXY protocol version sent to backends: 0 (off), 1 or 2")
+
+	h2cFlag := flag.Bool("h2c", false, "Accept cleartext HTTP/2 (h2c) on the plain listener")
+	upstreamProtoFlag := flag.String("upstream-proto", "", "Protocol spoken to backends: http1, h2 or h2c (default: HTTP/2 via ALPN for https backends, HTTP/1.1 otherwise)")
+
+	healthCheckFlag := flag.String("health-check", "http", "Health check protocol: http (GET /health) or grpc (grpc.health.v1)")
+	grpcServiceFlag := flag.String("grpc-health-service", "", "Service name used in gRPC health checks (empty for the whole server)")
+
+	maxInFlightFlag := flag.Int("max-inflight", 0, "Maximum requests in flight across all backends (0 disables)")
+	maxInFlightBackendFlag := flag.Int("max-inflight-per-backend", 0, "Maximum requests in flight on each backend (0 disables)")
+	adaptiveFlag := flag.String("adaptive", "off", "Adjust the in-flight caps to backend latency: off, aimd or gradient")
+	adaptiveTimeoutFlag := flag.Duration("adaptive-timeout", 5*time.Second, "Responses slower than this shrink the aimd limit")
+
+	queueSizeFlag := flag.Int("queue-size", 0, "Requests per route that may wait for a free backend (0 rejects right away)")
+	queueTimeoutFlag := flag.Duration("queue-timeout", time.Second, "Longest time a request waits in the queue")
+	queueOrderFlag := flag.String("queue-order", "fifo", "Order of waiting requests: fifo or priority")
+	priorityHeaderFlag := flag.String("priority-header", "X-Priority", "Header with the priority class of a request (low, normal, high, critical), honored from -trusted-proxies")
+	clientTiersFlag := flag.String("client-tiers", "", "Path to a JSON file giving clients a priority class")
+	shedFlag := flag.String("shed-thresholds", "low=0.6,normal=0.8,high=0.9", "Load at which each priority class is shed, as a fraction of -max-inflight or -shed-queue-depth")
+	shedQueueFlag := flag.Int("shed-queue-depth", 0, "Queued requests counted as full load by the load shedder (0 only looks at -max-inflight)")
+
+	dailyQuotaFlag := flag.Int64("daily-quota", 0, "Requests each client may make per calendar day (0 disables)")
+	monthlyQuotaFlag := flag.Int64("monthly-quota", 0, "Requests each client may make per calendar month (0 disables)")
+	quotaKeyFlag := flag.String("quota-key", "ip", "What quotas are counted by, e.g. header:X-API-Key (same syntax as rate_limit_key)")
+	quotaFileFlag := flag.String("quota-file", "", "File quota usage is saved to, so it survives restarts (in memory only if empty)")
+	quotaTZFlag := flag.String("quota-timezone", "UTC", "Time zone whose midnight starts a quota day")
+	quotaSaveFlag := flag.Duration("quota-save-interval", 10*time.Second, "How often quota usage is saved to -quota-file")
+
+	adminAddrFlag := flag.String("admin-addr", "localhost:8091", "Address of the admin API: host:port or unix:/path/to/socket (disabled if empty)")
+	adminAuthFlag := flag.String("admin-auth", "", "Path to a JSON file of admin API tokens and client certificate names with their roles (required unless -admin-addr is local; without it only a unix socket may change anything)")
+	adminCertFlag := flag.String("admin-tls-cert", "", "cert.pem:key.pem served by the admin API (plain HTTP if empty)")
+	adminCAFlag := flag.String("admin-client-ca", "", "PEM bundle verifying client certificates on the admin API (needs -admin-tls-cert)")
+	adminAuditFlag := flag.String("admin-audit-log", "", "File every mutating admin call is appended to as a JSON line (standard log if empty)")
+
+	routesFlag := flag.String("routes", "", "Path to a JSON file describing proxy routes and their header policies")
 
 	flag.Parse()
 
@@ -35,8 +116,16 @@ func main() {
 		strategyType = algorithms.LeastConnectionsStrategy
 	case "ip":
 		strategyType = algorithms.IPHashStrategy
+	case "ch":
+		strategyType = algorithms.ConsistentHashStrategy
 	default:
-		log.Fatalf("Unknown strategy: %s. Use one of: rr, wrr, lc, ip", *algoFlag)
+		log.Fatalf("Unknown strategy: %s. Use one of: rr, wrr, lc, ip, ch", *algoFlag)
+	}
+
+	var backendURLs []string
+	if *backendsFlag != "" {
+		backendURLs = strings.Split(*backendsFlag, ",")
+		*numFlag = len(backendURLs)
 	}
 
 	// Parse weights if provided
@@ -56,22 +145,282 @@ func main() {
 		}
 	}
 
+	// Load routes if provided
+	routes := loadbalancer.DefaultRoutes()
+	if *routesFlag != "" {
+		loaded, err := loadbalancer.LoadRoutes(*routesFlag)
+		if err != nil {
+			log.Fatalf("Invalid routes file: %v", err)
+		}
+		routes = loaded
+	}
+
+	// Counters shared with other load balancer instances
+	var counters ratelimiter.CounterStore
+	switch *counterStoreFlag {
+	case "local":
+	case "memory":
+		counters = ratelimiter.NewMemoryCounterStore()
+	case "redis":
+		counters = ratelimiter.NewRedisCounterStore(*redisAddrFlag, *redisPasswordFlag, 64, time.Second)
+	case "peers":
+		peers, err := ratelimiter.NewPeerCounterStore(strings.Split(*peersFlag, ","), int64(*slackFlag), *syncFlag)
+		if err != nil {
+			log.Fatalf("Invalid rate limit peers: %v", err)
+		}
+		counters = peers
+	default:
+		log.Fatalf("Unknown rate limit store: %s. Use one of: local, memory, redis, peers", *counterStoreFlag)
+	}
+	shared := counters != nil
+
+	// Global and per-client rate limits, evaluated on every route
+	var rateLimits []ratelimiter.Rule
+	if *globalRateFlag > 0 {
+		global := ratelimiter.Rule{
+			Name:      "global",
+			Algorithm: "token",
+			Rate:      *globalRateFlag,
+			Burst:     max(*globalBurstFlag, 1),
+			Key:       ratelimiter.GlobalKey,
+		}
+		if shared {
+			// Token buckets cannot be shared, count a one second sliding window instead
+			global.Algorithm = "sliding-counter"
+			global.Shared = true
+		}
+		rateLimits = append(rateLimits, global)
+	}
+	if *limiterFlag != "none" {
+		rateLimits = append(rateLimits, ratelimiter.Rule{
+			Name:      "client",
+			Algorithm: *limiterFlag,
+			Rate:      *rateFlag,
+			Burst:     cmp.Or(*burstFlag, *rateFlag),
+			Window:    ratelimiter.Duration(*windowFlag),
+			Shared:    shared,
+		})
+	}
+
+	resolver, err := clientip.NewResolver(strings.Split(*trustedFlag, ","))
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
+	// Configure TLS if requested
+	var tlsConfig *loadbalancer.TLSConfig
+	if *tlsAddrFlag != "" {
+		certs, err := loadbalancer.ParseCertificateFiles(strings.Split(*tlsCertsFlag, ","))
+		if err != nil {
+			log.Fatalf("Invalid certificates: %v", err)
+		}
+		tlsConfig = &loadbalancer.TLSConfig{
+			Addr:           *tlsAddrFlag,
+			Certificates:   certs,
+			MinVersion:     *tlsMinFlag,
+			ReloadInterval: *tlsReloadFlag,
+			RedirectHTTP:   *redirectFlag,
+
+			ClientAuth:       *clientAuthFlag,
+			ClientCAFile:     *clientCAFlag,
+			ClientCertHeader: *clientCertHeaderFlag,
+		}
+		if *tlsCiphersFlag != "" {
+			tlsConfig.CipherSuites = strings.Split(*tlsCiphersFlag, ",")
+		}
+	}
+
 	basePort := 8080
 
 	// Initialize server pool and backends
 	serverPool := loadbalancer.NewServerPool(strategyType)
 	for i := 0; i < *numFlag; i++ {
+		if backendURLs != nil {
+			if _, err := serverPool.AddBackendDynamic(strings.TrimSpace(backendURLs[i]), weights[i]); err != nil {
+				log.Fatalf("Invalid backend URL %s: %v", backendURLs[i], err)
+			}
+			continue
+		}
 		serverPool.AddBackendUsingIndex("http://localhost:", basePort+i, weights[i])
 	}
 	serverPool.InitStrategy(strategyType)
 
+	if *upstreamCAFlag != "" || *upstreamCertFlag != "" || *upstreamSNIFlag != "" || *upstreamInsecureFlag {
+		err := serverPool.SetUpstreamTLS(&loadbalancer.UpstreamTLSConfig{
+			CAFile:             *upstreamCAFlag,
+			CertFile:           *upstreamCertFlag,
+			KeyFile:            *upstreamKeyFlag,
+			ServerName:         *upstreamSNIFlag,
+			InsecureSkipVerify: *upstreamInsecureFlag,
+		})
+		if err != nil {
+			log.Fatalf("Invalid upstream TLS settings: %v", err)
+		}
+	}
+
+	if err := serverPool.SetUpstreamProtocol(*upstreamProtoFlag); err != nil {
+		log.Fatalf("Invalid upstream protocol: %v", err)
+	}
+
+	switch *healthCheckFlag {
+	case "http":
+	case "grpc":
+		serverPool.SetGRPCHealthCheck(*grpcServiceFlag)
+	default:
+		log.Fatalf("Unknown health check: %s. Use one of: http, grpc", *healthCheckFlag)
+	}
+
+	if *sendProxyFlag < 0 || *sendProxyFlag > 2 {
+		log.Fatalf("Invalid PROXY protocol version: %d. Use 0, 1 or 2", *sendProxyFlag)
+	}
+	if *sendProxyFlag != 0 && *modeFlag == "http" {
+		serverPool.EnableProxyProtocol(*sendProxyFlag)
+	}
+
+	if *modeFlag == "tcp" {
+		if backendURLs == nil {
+			log.Fatal("TCP mode needs -backends, e.g. tcp://localhost:5432")
+		}
+		go loadbalancer.StartHealthChecker(serverPool, 20*time.Second)
+		loadbalancer.StartTCPProxy(serverPool, loadbalancer.TCPConfig{
+			Addr:        *addrFlag,
+			IdleTimeout: *idleFlag,
+			DialTimeout: 5 * time.Second,
+
+			ProxyProtocol:     *acceptProxyFlag,
+			SendProxyProtocol: *sendProxyFlag,
+			Trusted:           resolver,
+		})
+		return
+	}
+	if *modeFlag == "udp" {
+		if backendURLs == nil {
+			log.Fatal("UDP mode needs -backends, e.g. udp://localhost:53")
+		}
+		loadbalancer.StartUDPProxy(serverPool, loadbalancer.UDPConfig{
+			Addr:           *addrFlag,
+			SessionTimeout: *idleFlag,
+			MaxSessions:    *udpSessionsFlag,
+		})
+		return
+	}
+	if *modeFlag != "http" {
+		log.Fatalf("Unknown mode: %s. Use one of: http, tcp, udp", *modeFlag)
+	}
+
 	// Start backend servers AFTER creating them
-	log.Println("Starting backend servers...")
-	go backend.RunServers(basePort, serverPool.GetBackends())
+	if backendURLs == nil {
+		log.Println("Starting backend servers...")
+		go backend.RunServers(basePort, serverPool.GetBackends())
+	}
 
 	// Start health checker
 	go loadbalancer.StartHealthChecker(serverPool, 20*time.Second)
 
-	// Start proxy server
-	loadbalancer.StartProxy(":8090", serverPool, *limiterFlag, *rateFlag, *burstFlag)
+	// Priority classes, lower ones are shed first under overload
+	shedThresholds, err := loadbalancer.ParseShedThresholds(*shedFlag)
+	if err != nil {
+		log.Fatalf("Invalid shed thresholds: %v", err)
+	}
+	var clientTiers *loadbalancer.ClientTiers
+	if *clientTiersFlag != "" {
+		if clientTiers, err = loadbalancer.LoadClientTiers(*clientTiersFlag, resolver); err != nil {
+			log.Fatalf("Invalid client tiers: %v", err)
+		}
+	}
+
+	// Daily and monthly quotas per client
+	var quotas []quota.Quota
+	if *dailyQuotaFlag > 0 {
+		quotas = append(quotas, quota.Quota{Name: "daily", Limit: *dailyQuotaFlag, Period: quota.Daily})
+	}
+	if *monthlyQuotaFlag > 0 {
+		quotas = append(quotas, quota.Quota{Name: "monthly", Limit: *monthlyQuotaFlag, Period: quota.Monthly})
+	}
+	var quotaManager *quota.Manager
+	if len(quotas) > 0 {
+		loc, err := time.LoadLocation(*quotaTZFlag)
+		if err != nil {
+			log.Fatalf("Invalid quota time zone: %v", err)
+		}
+		quotaKey, err := ratelimiter.ParseKey(*quotaKeyFlag, resolver.ClientIP)
+		if err != nil {
+			log.Fatalf("Invalid quota key: %v", err)
+		}
+		if quotaManager, err = quota.NewManager(quotas, quotaKey, loc, *quotaFileFlag, nil); err != nil {
+			log.Fatalf("Loading quotas failed: %v", err)
+		}
+		quotaManager.StartSaving(*quotaSaveFlag)
+	}
+
+	// The admin API listens separately and only lets known callers change the pool
+	adminConfig := loadbalancer.AdminConfig{Addr: *adminAddrFlag}
+	if *adminAuthFlag != "" {
+		auth, err := loadbalancer.LoadAdminAuth(*adminAuthFlag)
+		if err != nil {
+			log.Fatalf("Loading admin credentials failed: %v", err)
+		}
+		adminConfig.Auth = auth
+	}
+	if *adminCertFlag != "" {
+		certs, err := loadbalancer.ParseCertificateFiles([]string{*adminCertFlag})
+		if err != nil {
+			log.Fatalf("Invalid admin certificate: %v", err)
+		}
+		adminConfig.TLS = &loadbalancer.TLSConfig{Certificates: certs, ReloadInterval: *tlsReloadFlag}
+		if *adminCAFlag != "" {
+			adminConfig.TLS.ClientAuth = "request" // callers may still use a token instead
+			adminConfig.TLS.ClientCAFile = *adminCAFlag
+		}
+	} else if *adminCAFlag != "" {
+		log.Fatalf("-admin-client-ca needs -admin-tls-cert")
+	}
+	if *adminAuditFlag != "" {
+		auditFile, err := os.OpenFile(*adminAuditFlag, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			log.Fatalf("Opening admin audit log failed: %v", err)
+		}
+		adminConfig.AuditLog = auditFile
+	}
+
+	// Serve until interrupted, then finish requests in flight and save the quotas
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	loadbalancer.StartProxy(ctx, serverPool, loadbalancer.ProxyConfig{
+		Addr:       *addrFlag,
+		Routes:     routes,
+		RateLimits: rateLimits,
+		Counters:   counters,
+		PeerAddr:   *peerAddrFlag,
+		MaxClients: *maxClientsFlag,
+		ClientTTL:  *clientTTLFlag,
+		ClientIP:   resolver,
+		TLS:        tlsConfig,
+		Quotas:     quotaManager,
+
+		Concurrency: loadbalancer.ConcurrencyConfig{
+			MaxInFlight:           *maxInFlightFlag,
+			MaxInFlightPerBackend: *maxInFlightBackendFlag,
+			Adaptive:              *adaptiveFlag,
+			Timeout:               *adaptiveTimeoutFlag,
+		},
+		Queue: &loadbalancer.QueueConfig{
+			Size:    *queueSizeFlag,
+			Timeout: ratelimiter.Duration(*queueTimeoutFlag),
+		},
+		QueueOrder: *queueOrderFlag,
+		Priority: loadbalancer.PriorityConfig{
+			Header:         *priorityHeaderFlag,
+			Tiers:          clientTiers,
+			ShedAt:         shedThresholds,
+			ShedQueueDepth: *shedQueueFlag,
+		},
+
+		ProxyProtocol: *acceptProxyFlag,
+
+		TunnelIdleTimeout: *idleFlag,
+		H2C:               *h2cFlag,
+
+		Admin: adminConfig,
+	})
 }
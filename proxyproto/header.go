@@ -0,0 +1,197 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v2Signature starts every PROXY protocol v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var v1Prefix = []byte("PROXY ")
+
+// maxV2Payload bounds the address block and TLVs of a v2 header. Real headers
+// are a few hundred bytes at most, anything larger is not worth buffering.
+const maxV2Payload = 4096
+
+// ErrNoHeader is returned when the connection does not start with a PROXY header
+var ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+
+// Header is a parsed PROXY protocol header. Source and Destination are nil
+// when the sender did not proxy the connection (v1 UNKNOWN or v2 LOCAL).
+type Header struct {
+	Version     int
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+}
+
+// Read parses a v1 or v2 header from the start of r
+func Read(r *bufio.Reader) (*Header, error) {
+	peek, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, ErrNoHeader
+	}
+	if bytes.Equal(peek, v1Prefix) {
+		return readV1(r)
+	}
+
+	peek, err = r.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(peek, v2Signature) {
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 parses "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n"
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < 107 { // longest possible v1 header
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxyproto: reading v1 header: %v", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxyproto: v1 header too long or not terminated")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &Header{Version: 1}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	if isV4 := fields[1] == "TCP4"; (src.IP.To4() != nil) != isV4 || (dst.IP.To4() != nil) != isV4 {
+		return nil, fmt.Errorf("proxyproto: addresses do not match protocol %s", fields[1])
+	}
+	return &Header{Version: 1, Source: src, Destination: dst}, nil
+}
+
+func parseV1Addr(ip, port string) (*net.TCPAddr, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, fmt.Errorf("proxyproto: invalid address %q", ip)
+	}
+	parsedPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: parsedIP, Port: int(parsedPort)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("proxyproto: reading v2 header: %v", err)
+	}
+
+	verCmd, family := fixed[12], fixed[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("proxyproto: unsupported version %d", verCmd>>4)
+	}
+	if cmd := verCmd & 0x0F; cmd > 0x01 {
+		return nil, fmt.Errorf("proxyproto: unknown v2 command %d", cmd)
+	}
+
+	length := binary.BigEndian.Uint16(fixed[14:16])
+	if length > maxV2Payload {
+		return nil, fmt.Errorf("proxyproto: v2 header of %d bytes is too long", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("proxyproto: reading v2 addresses: %v", err)
+	}
+
+	header := &Header{Version: 2}
+	if verCmd&0x0F == 0x00 { // LOCAL, e.g. a health check from the proxy itself
+		return header, nil
+	}
+
+	// TLVs after the addresses are ignored
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errors.New("proxyproto: short v2 IPv4 address block")
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errors.New("proxyproto: short v2 IPv6 address block")
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	}
+	return header, nil
+}
+
+// Format encodes a header announcing a connection from src to dst in the given version
+func Format(version int, src, dst net.Addr) ([]byte, error) {
+	srcTCP, srcOK := toTCPAddr(src)
+	dstTCP, dstOK := toTCPAddr(dst)
+
+	switch version {
+	case 1:
+		if !srcOK || !dstOK {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP4"
+		if srcTCP.IP.To4() == nil {
+			proto = "TCP6"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", proto, srcTCP.IP, dstTCP.IP, srcTCP.Port, dstTCP.Port), nil
+	case 2:
+		buf := append([]byte{}, v2Signature...)
+		if !srcOK || !dstOK {
+			return append(buf, 0x20, 0x00, 0x00, 0x00), nil // LOCAL
+		}
+
+		var addrs []byte
+		family := byte(0x11) // AF_INET, STREAM
+		if src4, dst4 := srcTCP.IP.To4(), dstTCP.IP.To4(); src4 != nil && dst4 != nil {
+			addrs = append(append(addrs, src4...), dst4...)
+		} else {
+			family = 0x21 // AF_INET6, STREAM
+			addrs = append(append(addrs, srcTCP.IP.To16()...), dstTCP.IP.To16()...)
+		}
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(srcTCP.Port))
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(dstTCP.Port))
+
+		buf = append(buf, 0x21, family) // version 2, PROXY command
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(addrs)))
+		return append(buf, addrs...), nil
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown version %d", version)
+	}
+}
+
+func toTCPAddr(addr net.Addr) (*net.TCPAddr, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a, a != nil && a.IP != nil
+	case *net.UDPAddr:
+		return &net.TCPAddr{IP: a.IP, Port: a.Port}, a != nil && a.IP != nil
+	}
+	return nil, false
+}
@@ -0,0 +1,166 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// v2 builds a v2 header with the given version/command, family and payload
+func v2(verCmd, family byte, payload []byte) []byte {
+	buf := append([]byte{}, v2Signature...)
+	buf = append(buf, verCmd, family)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	return append(buf, payload...)
+}
+
+var v4Payload = []byte{
+	192, 0, 2, 1, // source
+	198, 51, 100, 1, // destination
+	0x30, 0x39, // 12345
+	0x01, 0xBB, // 443
+}
+
+func TestRead(t *testing.T) {
+	v6Payload := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x30, 0x39, 0x00, 0x50)
+	withTLV := append(append([]byte{}, v4Payload...), 0x04, 0x00, 0x03, 'a', 'b', 'c') // TLV after the addresses
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    *Header
+		wantErr error // nil for success, ErrNoHeader, or any other error if errAny
+		errAny  bool
+	}{
+		{name: "v1 TCP4", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\nGET /"),
+			want: &Header{Version: 1, Source: tcp("192.0.2.1", 12345), Destination: tcp("198.51.100.1", 443)}},
+		{name: "v1 TCP6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 80\r\n"),
+			want: &Header{Version: 1, Source: tcp("2001:db8::1", 12345), Destination: tcp("2001:db8::2", 80)}},
+		{name: "v1 UNKNOWN", input: []byte("PROXY UNKNOWN\r\n"), want: &Header{Version: 1}},
+		{name: "v1 UNKNOWN with addresses", input: []byte("PROXY UNKNOWN 1.2.3.4 5.6.7.8 1 2\r\n"), want: &Header{Version: 1}},
+		{name: "v1 truncated", input: []byte("PROXY TCP4 192.0.2.1 198.51"), errAny: true},
+		{name: "v1 without CRLF", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n"), errAny: true},
+		{name: "v1 too long", input: []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), errAny: true},
+		{name: "v1 bad address", input: []byte("PROXY TCP4 192.0.2.999 198.51.100.1 1 2\r\n"), errAny: true},
+		{name: "v1 bad port", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1 70000\r\n"), errAny: true},
+		{name: "v1 family mismatch", input: []byte("PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\n"), errAny: true},
+		{name: "v1 missing fields", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1\r\n"), errAny: true},
+		{name: "v1 unknown protocol", input: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n"), errAny: true},
+
+		{name: "v2 IPv4", input: v2(0x21, 0x11, v4Payload),
+			want: &Header{Version: 2, Source: tcp("192.0.2.1", 12345), Destination: tcp("198.51.100.1", 443)}},
+		{name: "v2 IPv6", input: v2(0x21, 0x21, v6Payload),
+			want: &Header{Version: 2, Source: tcp("2001:db8::1", 12345), Destination: tcp("2001:db8::2", 80)}},
+		{name: "v2 TLVs are skipped", input: v2(0x21, 0x11, withTLV),
+			want: &Header{Version: 2, Source: tcp("192.0.2.1", 12345), Destination: tcp("198.51.100.1", 443)}},
+		{name: "v2 LOCAL", input: v2(0x20, 0x00, nil), want: &Header{Version: 2}},
+		{name: "v2 LOCAL ignores addresses", input: v2(0x20, 0x11, v4Payload), want: &Header{Version: 2}},
+		{name: "v2 UNSPEC family", input: v2(0x21, 0x00, nil), want: &Header{Version: 2}},
+		{name: "v2 unix family", input: v2(0x21, 0x31, make([]byte, 216)), want: &Header{Version: 2}},
+		{name: "v2 short IPv4 block", input: v2(0x21, 0x11, v4Payload[:8]), errAny: true},
+		{name: "v2 short IPv6 block", input: v2(0x21, 0x21, v6Payload[:20]), errAny: true},
+		{name: "v2 truncated fixed part", input: v2(0x21, 0x11, v4Payload)[:14], errAny: true},
+		{name: "v2 truncated payload", input: v2(0x21, 0x11, v4Payload)[:20], errAny: true},
+		{name: "v2 oversized length", input: append(append(append([]byte{}, v2Signature...), 0x21, 0x11, 0xFF, 0xFF), v4Payload...), errAny: true},
+		{name: "v2 wrong version", input: v2(0x11, 0x11, v4Payload), errAny: true},
+		{name: "v2 unknown command", input: v2(0x22, 0x11, v4Payload), errAny: true},
+
+		{name: "plain HTTP", input: []byte("GET / HTTP/1.1\r\n"), wantErr: ErrNoHeader},
+		{name: "short input", input: []byte("PRO"), wantErr: ErrNoHeader},
+		{name: "partial v2 signature", input: v2Signature[:8], wantErr: ErrNoHeader},
+		{name: "empty", input: nil, wantErr: ErrNoHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := Read(bufio.NewReader(bytes.NewReader(tt.input)))
+			switch {
+			case tt.errAny:
+				if err == nil || err == ErrNoHeader {
+					t.Fatalf("got %+v, %v, want a parse error", header, err)
+				}
+				return
+			case err != tt.wantErr:
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			case err != nil:
+				return
+			}
+			assertHeader(t, header, tt.want)
+		})
+	}
+}
+
+func TestReadLeavesPayloadUnread(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader(append(v2(0x21, 0x11, v4Payload), "hello"...)))
+	if _, err := Read(r); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "hello" {
+		t.Errorf("data after header = %q, want hello", rest)
+	}
+}
+
+func TestFormatReadRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		want     *Header // Version is filled in per test
+	}{
+		{"IPv4", tcp("192.0.2.1", 12345), tcp("198.51.100.1", 443),
+			&Header{Source: tcp("192.0.2.1", 12345), Destination: tcp("198.51.100.1", 443)}},
+		{"IPv6", tcp("2001:db8::1", 1), tcp("2001:db8::2", 65535),
+			&Header{Source: tcp("2001:db8::1", 1), Destination: tcp("2001:db8::2", 65535)}},
+		{"UDP addresses", &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}, &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 53},
+			&Header{Source: tcp("192.0.2.1", 53), Destination: tcp("192.0.2.2", 53)}},
+		{"unknown addresses", &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, tcp("192.0.2.2", 80), &Header{}},
+		{"nil address", nil, tcp("192.0.2.2", 80), &Header{}},
+	}
+
+	for _, tt := range tests {
+		for _, version := range []int{1, 2} {
+			buf, err := Format(version, tt.src, tt.dst)
+			if err != nil {
+				t.Fatalf("%s v%d: %v", tt.name, version, err)
+			}
+			header, err := Read(bufio.NewReader(bytes.NewReader(buf)))
+			if err != nil {
+				t.Fatalf("%s v%d: reading %q: %v", tt.name, version, buf, err)
+			}
+			want := *tt.want
+			want.Version = version
+			assertHeader(t, header, &want)
+		}
+	}
+
+	if _, err := Format(3, nil, nil); err == nil {
+		t.Error("version 3 accepted")
+	}
+}
+
+func tcp(ip string, port int) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func assertHeader(t *testing.T, got, want *Header) {
+	t.Helper()
+	if got.Version != want.Version {
+		t.Errorf("version = %d, want %d", got.Version, want.Version)
+	}
+	for _, pair := range []struct {
+		name      string
+		got, want *net.TCPAddr
+	}{{"source", got.Source, want.Source}, {"destination", got.Destination, want.Destination}} {
+		if (pair.got == nil) != (pair.want == nil) {
+			t.Errorf("%s = %v, want %v", pair.name, pair.got, pair.want)
+			continue
+		}
+		if pair.got != nil && (!pair.got.IP.Equal(pair.want.IP) || pair.got.Port != pair.want.Port) {
+			t.Errorf("%s = %v, want %v", pair.name, pair.got, pair.want)
+		}
+	}
+}
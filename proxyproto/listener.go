@@ -0,0 +1,95 @@
+package proxyproto
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener parses PROXY protocol headers on connections from trusted peers and
+// reports the announced client as the connection's RemoteAddr. Connections from
+// other peers are passed through untouched, so they cannot fake their address.
+type Listener struct {
+	net.Listener
+	Trusted       func(ip string) bool
+	HeaderTimeout time.Duration // how long a trusted peer may take to send the header
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if l.Trusted == nil || !l.Trusted(host) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.HeaderTimeout}, nil
+}
+
+// Conn reads the PROXY header lazily on first use, so a slow peer does not block Accept
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	header  *Header
+	err     error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		header, err := Read(c.reader)
+		if err == ErrNoHeader {
+			return // trusted peer talking directly, keep its own address
+		}
+		if err != nil {
+			log.Printf("Invalid PROXY header from %s: %v", c.Conn.RemoteAddr(), err)
+			c.err = err
+			return
+		}
+		c.header = header
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client announced in the PROXY header, if any
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination announced in the PROXY header, if any
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite lets TCP splicing half-close the underlying connection
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,105 @@
+package proxyproto
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// accept wraps a loopback listener and returns the server side of one connection
+// after the client wrote data
+func accept(t *testing.T, trusted bool, timeout time.Duration, data []byte) (net.Conn, net.Conn) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { inner.Close() })
+	l := &Listener{Listener: inner, Trusted: func(string) bool { return trusted }, HeaderTimeout: timeout}
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if len(data) > 0 {
+		client.Write(data)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, client
+}
+
+func TestListener(t *testing.T) {
+	header, _ := Format(2, tcp("203.0.113.7", 4000), tcp("198.51.100.1", 443))
+
+	tests := []struct {
+		name       string
+		trusted    bool
+		input      []byte
+		wantRemote string // empty means the peer's own address
+		wantData   string
+		wantErr    bool
+	}{
+		{name: "trusted with header", trusted: true, input: append(header, "hello"...), wantRemote: "203.0.113.7:4000", wantData: "hello"},
+		{name: "trusted without header", trusted: true, input: []byte("hello"), wantData: "hello"},
+		{name: "untrusted header is data", trusted: false, input: append(header, "hello"...), wantData: string(header) + "hello"},
+		{name: "trusted with broken header", trusted: true, input: []byte("PROXY TCP4 nonsense\r\n"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := accept(t, tt.trusted, time.Second, tt.input)
+			client.(*net.TCPConn).CloseWrite()
+
+			wantRemote := tt.wantRemote
+			if wantRemote == "" {
+				wantRemote = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); !tt.wantErr && got != wantRemote {
+				t.Errorf("RemoteAddr = %s, want %s", got, wantRemote)
+			}
+
+			data, err := io.ReadAll(conn)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("read %q, want an error", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("data = %q, want %q", data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestListenerHeaderTimeout(t *testing.T) {
+	conn, client := accept(t, true, 50*time.Millisecond, nil)
+
+	// a silent trusted peer keeps its own address once the header timeout passes
+	start := time.Now()
+	if got := conn.RemoteAddr().String(); got != client.LocalAddr().String() {
+		t.Errorf("RemoteAddr = %s, want %s", got, client.LocalAddr())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RemoteAddr took %v", elapsed)
+	}
+
+	// and the connection stays usable afterwards
+	client.Write([]byte("late\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "late\n" {
+		t.Errorf("read %q, %v after the header timeout", line, err)
+	}
+}
@@ -0,0 +1,24 @@
+package quota
+
+import "time"
+
+// Period is the calendar window a quota resets on
+type Period string
+
+const (
+	Daily   Period = "day"
+	Monthly Period = "month"
+)
+
+// bounds returns the start and end of the period containing now, in loc
+func (p Period) bounds(now time.Time, loc *time.Location) (time.Time, time.Time) {
+	now = now.In(loc)
+	switch p {
+	case Monthly:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
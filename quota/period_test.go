@@ -0,0 +1,54 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodBounds(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	date := func(loc *time.Location, y int, m time.Month, d, h int) time.Time {
+		return time.Date(y, m, d, h, 0, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name       string
+		period     Period
+		now        time.Time
+		loc        *time.Location
+		start, end time.Time
+	}{
+		{"day", Daily, date(time.UTC, 2024, 5, 17, 13), time.UTC,
+			date(time.UTC, 2024, 5, 17, 0), date(time.UTC, 2024, 5, 18, 0)},
+		{"midnight starts a day", Daily, date(time.UTC, 2024, 5, 17, 0), time.UTC,
+			date(time.UTC, 2024, 5, 17, 0), date(time.UTC, 2024, 5, 18, 0)},
+		{"unknown period is daily", Period("week"), date(time.UTC, 2024, 5, 17, 13), time.UTC,
+			date(time.UTC, 2024, 5, 17, 0), date(time.UTC, 2024, 5, 18, 0)},
+		{"day in the quota's time zone", Daily, date(time.UTC, 2024, 5, 17, 2), newYork,
+			date(newYork, 2024, 5, 16, 0), date(newYork, 2024, 5, 17, 0)},
+		{"day losing an hour to daylight saving", Daily, date(newYork, 2024, 3, 10, 12), newYork,
+			date(newYork, 2024, 3, 10, 0), date(newYork, 2024, 3, 11, 0)},
+		{"month", Monthly, date(time.UTC, 2024, 5, 17, 13), time.UTC,
+			date(time.UTC, 2024, 5, 1, 0), date(time.UTC, 2024, 6, 1, 0)},
+		{"leap february", Monthly, date(time.UTC, 2024, 2, 29, 23), time.UTC,
+			date(time.UTC, 2024, 2, 1, 0), date(time.UTC, 2024, 3, 1, 0)},
+		{"december ends the year", Monthly, date(time.UTC, 2024, 12, 31, 23), time.UTC,
+			date(time.UTC, 2024, 12, 1, 0), date(time.UTC, 2025, 1, 1, 0)},
+		{"month in the quota's time zone", Monthly, date(time.UTC, 2024, 6, 1, 2), newYork,
+			date(newYork, 2024, 5, 1, 0), date(newYork, 2024, 6, 1, 0)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end := tc.period.bounds(tc.now, tc.loc)
+			if !start.Equal(tc.start) || !end.Equal(tc.end) {
+				t.Errorf("bounds = %v - %v, want %v - %v", start, end, tc.start, tc.end)
+			}
+		})
+	}
+	if start, end := Daily.bounds(date(newYork, 2024, 3, 10, 12), newYork); end.Sub(start) != 23*time.Hour {
+		t.Errorf("daylight saving day lasts %v, want 23h", end.Sub(start))
+	}
+}
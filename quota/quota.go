@@ -0,0 +1,310 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+	"golang-load-balancer/ratelimiter"
+)
+
+// Quota caps how many units a client may use per calendar day or month
+type Quota struct {
+	Name   string
+	Limit  int64
+	Period Period
+}
+
+// Status is a client's usage of one quota
+type Status struct {
+	Quota     string        `json:"quota"`
+	Allowed   bool          `json:"-"`
+	Limit     int64         `json:"limit"`
+	Used      int64         `json:"used"`
+	Remaining int64         `json:"remaining"`
+	Reset     time.Duration `json:"-"`
+	ResetAt   time.Time     `json:"reset_at"`
+}
+
+// SetHeaders writes the X-Quota headers, and Retry-After on denied requests
+func (s Status) SetHeaders(h http.Header) {
+	if s.Limit <= 0 {
+		return
+	}
+	reset := strconv.FormatInt(int64((s.Reset+time.Second-1)/time.Second), 10)
+	h.Set("X-Quota-Limit", strconv.FormatInt(s.Limit, 10))
+	h.Set("X-Quota-Remaining", strconv.FormatInt(s.Remaining, 10))
+	h.Set("X-Quota-Reset", reset)
+	if !s.Allowed {
+		h.Set("Retry-After", reset)
+	}
+}
+
+// Manager tracks the quota usage of every client and persists it to a file,
+// so usage survives restarts
+type Manager struct {
+	quotas []Quota
+	key    ratelimiter.KeyFunc
+	loc    *time.Location
+	path   string // empty keeps usage in memory only
+	usage  map[string]*usage
+	dirty  bool
+	clock  clock.Clock
+	mutex  sync.Mutex
+}
+
+type usage struct {
+	Start time.Time `json:"start"` // of the period the count belongs to
+	Used  int64     `json:"used"`
+}
+
+// NewManager loads saved usage from path if it exists. Periods start at
+// midnight in loc; clk may be nil for the system clock.
+func NewManager(quotas []Quota, key ratelimiter.KeyFunc, loc *time.Location, path string, clk clock.Clock) (*Manager, error) {
+	m := &Manager{quotas: quotas, key: key, loc: loc, path: path, usage: make(map[string]*usage), clock: clock.OrReal(clk)}
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.usage); err != nil {
+		return nil, fmt.Errorf("parsing quota file %s: %v", path, err)
+	}
+	log.Printf("Loaded %d quota counters from %s", len(m.usage), path)
+	return m, nil
+}
+
+// Key returns the client a request is counted for
+func (m *Manager) Key(r *http.Request) string {
+	return m.key(r)
+}
+
+func usageKey(quota, client string) string {
+	return quota + "|" + client
+}
+
+// current returns the usage of a client in the current period, must be called with the lock held
+func (m *Manager) current(q Quota, client string, now time.Time) (*usage, time.Time) {
+	start, end := q.Period.bounds(now, m.loc)
+	u, ok := m.usage[usageKey(q.Name, client)]
+	if !ok || !u.Start.Equal(start) {
+		u = &usage{Start: start}
+		m.usage[usageKey(q.Name, client)] = u
+	}
+	return u, end
+}
+
+func (m *Manager) status(q Quota, u *usage, end, now time.Time) Status {
+	return Status{
+		Quota:     q.Name,
+		Allowed:   true,
+		Limit:     q.Limit,
+		Used:      u.Used,
+		Remaining: max(q.Limit-u.Used, 0),
+		Reset:     end.Sub(now),
+		ResetAt:   end,
+	}
+}
+
+// Allow takes n units from every quota of the request's client, or none if
+// any of them would be exceeded. It returns the status of the quota closest
+// to running out, or the one that denied the request.
+func (m *Manager) Allow(r *http.Request, n int) Status {
+	client := m.key(r)
+	now := m.clock.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var result Status
+	usages := make([]*usage, len(m.quotas))
+	for i, q := range m.quotas {
+		u, end := m.current(q, client, now)
+		usages[i] = u
+		if u.Used+int64(n) > q.Limit {
+			denied := m.status(q, u, end, now)
+			denied.Allowed = false
+			return denied
+		}
+		s := m.status(q, u, end, now)
+		s.Remaining = max(s.Remaining-int64(n), 0)
+		if i == 0 || s.Remaining < result.Remaining {
+			result = s
+		}
+	}
+
+	for _, u := range usages {
+		u.Used += int64(n)
+	}
+	m.dirty = true
+	return result
+}
+
+// Charge takes n more units from the request's client after the fact. Usage
+// stops at the limit, n <= 0 is ignored.
+func (m *Manager) Charge(r *http.Request, n int) {
+	if n <= 0 {
+		return
+	}
+	client := m.key(r)
+	now := m.clock.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, q := range m.quotas {
+		u, _ := m.current(q, client, now)
+		u.Used += min(int64(n), max(q.Limit-u.Used, 0))
+	}
+	m.dirty = true
+}
+
+// Refund gives back n units Allow took for a request that was not served after all
+func (m *Manager) Refund(r *http.Request, n int) {
+	if n <= 0 {
+		return
+	}
+	client := m.key(r)
+	now := m.clock.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, q := range m.quotas {
+		u, _ := m.current(q, client, now)
+		u.Used = max(u.Used-int64(n), 0)
+	}
+	m.dirty = true
+}
+
+// Usage returns a client's status for every quota
+func (m *Manager) Usage(client string) []Status {
+	now := m.clock.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(m.quotas))
+	for _, q := range m.quotas {
+		start, end := q.Period.bounds(now, m.loc)
+		u := &usage{Start: start}
+		if saved, ok := m.usage[usageKey(q.Name, client)]; ok && saved.Start.Equal(start) {
+			u = saved
+		}
+		statuses = append(statuses, m.status(q, u, end, now))
+	}
+	return statuses
+}
+
+// Reset clears a client's usage of the named quota, or of all quotas if name is empty
+func (m *Manager) Reset(client, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	found := false
+	for _, q := range m.quotas {
+		if name == "" || q.Name == name {
+			delete(m.usage, usageKey(q.Name, client))
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown quota %q", name)
+	}
+	m.dirty = true
+	return nil
+}
+
+// Save drops usage of past periods and writes the rest to the file, replacing
+// it atomically. Without a file it only drops the old usage.
+func (m *Manager) Save() error {
+	m.mutex.Lock()
+	m.prune(m.clock.Now())
+	if m.path == "" || !m.dirty {
+		m.mutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(m.usage)
+	m.dirty = false
+	m.mutex.Unlock()
+
+	if err == nil {
+		err = writeFile(m.path, data)
+	}
+	if err != nil {
+		m.mutex.Lock()
+		m.dirty = true // try again on the next save
+		m.mutex.Unlock()
+	}
+	return err
+}
+
+// prune drops usage from a past period or of a removed quota, must be called with the lock held
+func (m *Manager) prune(now time.Time) {
+	for key, u := range m.usage {
+		name, _, _ := strings.Cut(key, "|")
+		if q, ok := m.quota(name); !ok || !u.Start.Equal(startOf(q, now, m.loc)) {
+			delete(m.usage, key)
+		}
+	}
+}
+
+// writeFile replaces path with data through a temporary file, so a crash never leaves it half written
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// StartSaving saves usage every interval, which also keeps clients of past
+// periods from piling up in memory. Usage counted since the last save is lost
+// on a crash.
+func (m *Manager) StartSaving(interval time.Duration) {
+	go func() {
+		for {
+			m.clock.Sleep(interval)
+			if err := m.Save(); err != nil {
+				log.Printf("Saving quota usage failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (m *Manager) quota(name string) (Quota, bool) {
+	for _, q := range m.quotas {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return Quota{}, false
+}
+
+func startOf(q Quota, now time.Time, loc *time.Location) time.Time {
+	start, _ := q.Period.bounds(now, loc)
+	return start
+}
@@ -0,0 +1,211 @@
+package quota
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// epoch is a Friday in the middle of a month
+var epoch = time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)
+
+var testQuotas = []Quota{
+	{Name: "daily", Limit: 10, Period: Daily},
+	{Name: "monthly", Limit: 15, Period: Monthly},
+}
+
+// clientKey counts requests by their X-Client header
+func clientKey(r *http.Request) string {
+	return r.Header.Get("X-Client")
+}
+
+func request(client string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "http://lb.test/", nil)
+	r.Header.Set("X-Client", client)
+	return r
+}
+
+func TestManagerCharge(t *testing.T) {
+	tests := []struct {
+		name   string
+		charge int
+		used   int64
+	}{
+		{"adds to usage", 3, 4},
+		{"stops at the limit", math.MaxInt, 10},
+		{"ignores negative costs", -5, 1},
+		{"ignores zero", 0, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := NewManager([]Quota{{Name: "daily", Limit: 10, Period: Daily}}, clientKey, time.UTC, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Allow(request("a"), 1)
+			m.Charge(request("a"), tc.charge)
+			if used := m.Usage("a")[0].Used; used != tc.used {
+				t.Errorf("used = %d, want %d", used, tc.used)
+			}
+		})
+	}
+}
+
+func newTestManager(t *testing.T, clk clock.Clock, path string) *Manager {
+	t.Helper()
+	m, err := NewManager(testQuotas, clientKey, time.UTC, path, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestManagerAllow(t *testing.T) {
+	m := newTestManager(t, clock.NewFake(epoch), "")
+
+	s := m.Allow(request("a"), 4)
+	if !s.Allowed || s.Quota != "daily" || s.Remaining != 6 {
+		t.Errorf("first request = %+v, want daily allowed with 6 remaining", s)
+	}
+	if s.Reset != 12*time.Hour {
+		t.Errorf("reset = %v, want 12h until midnight", s.Reset)
+	}
+	// the daily quota has room for 7 but the request is denied as a whole
+	if s := m.Allow(request("a"), 7); s.Allowed || s.Quota != "daily" {
+		t.Errorf("request over the daily quota = %+v, want denied by daily", s)
+	}
+	if used := m.Usage("a")[1].Used; used != 4 {
+		t.Errorf("monthly used = %d after a denied request, want 4", used)
+	}
+	if s := m.Allow(request("b"), 10); !s.Allowed {
+		t.Errorf("other client = %+v, want allowed", s)
+	}
+
+	m.Refund(request("a"), 4)
+	if used := m.Usage("a")[0].Used; used != 0 {
+		t.Errorf("used = %d after a refund, want 0", used)
+	}
+}
+
+func TestManagerRollover(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	m := newTestManager(t, clk, "")
+	m.Allow(request("a"), 10)
+
+	clk.Advance(12*time.Hour - time.Nanosecond)
+	if s := m.Allow(request("a"), 1); s.Allowed {
+		t.Error("request allowed before midnight")
+	}
+	clk.Advance(time.Nanosecond)
+	if s := m.Allow(request("a"), 1); !s.Allowed || s.Quota != "monthly" || s.Remaining != 4 {
+		t.Errorf("request after midnight = %+v, want monthly closest to running out with 4 remaining", s)
+	}
+
+	// a new month resets both
+	clk.Advance(15 * 24 * time.Hour)
+	statuses := m.Usage("a")
+	if statuses[0].Used != 0 || statuses[1].Used != 0 {
+		t.Errorf("usage in June = %+v, want none", statuses)
+	}
+}
+
+func TestManagerReset(t *testing.T) {
+	m := newTestManager(t, clock.NewFake(epoch), "")
+	m.Allow(request("a"), 5)
+
+	if err := m.Reset("a", "unknown"); err == nil {
+		t.Error("reset of an unknown quota succeeded")
+	}
+	if err := m.Reset("a", "daily"); err != nil {
+		t.Fatal(err)
+	}
+	statuses := m.Usage("a")
+	if statuses[0].Used != 0 || statuses[1].Used != 5 {
+		t.Errorf("usage after resetting daily = %+v, want 0 and 5", statuses)
+	}
+	if err := m.Reset("a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if used := m.Usage("a")[1].Used; used != 0 {
+		t.Errorf("monthly used = %d after resetting all, want 0", used)
+	}
+}
+
+func TestManagerSaveLoad(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	path := filepath.Join(t.TempDir(), "quota.json")
+	m := newTestManager(t, clk, path)
+	m.Allow(request("a"), 3)
+	m.Allow(request("b"), 5)
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestManager(t, clk, path)
+	for client, want := range map[string]int64{"a": 3, "b": 5, "c": 0} {
+		statuses := loaded.Usage(client)
+		if statuses[0].Used != want || statuses[1].Used != want {
+			t.Errorf("loaded usage of %s = %+v, want %d", client, statuses, want)
+		}
+	}
+
+	// the next day's save drops the daily usage but keeps the month
+	clk.Advance(24 * time.Hour)
+	loaded.Allow(request("a"), 1)
+	if err := loaded.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.usage) != 3 {
+		t.Errorf("%d counters saved, want monthly of a and b, and daily of a", len(loaded.usage))
+	}
+	again := newTestManager(t, clk, path)
+	if s := again.Usage("a"); s[0].Used != 1 || s[1].Used != 4 {
+		t.Errorf("usage of a after the rollover = %+v, want 1 and 4", s)
+	}
+}
+
+func TestNewManagerErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewManager(testQuotas, clientKey, time.UTC, filepath.Join(dir, "missing.json"), nil); err != nil {
+		t.Errorf("missing file: %v, want a fresh start", err)
+	}
+	corrupt := filepath.Join(dir, "corrupt.json")
+	os.WriteFile(corrupt, []byte("{"), 0o600)
+	if _, err := NewManager(testQuotas, clientKey, time.UTC, corrupt, nil); err == nil {
+		t.Error("corrupt file loaded")
+	}
+}
+
+func TestStartSavingPrunesWithoutFile(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	m := newTestManager(t, clk, "")
+	m.Allow(request("a"), 1)
+	m.StartSaving(time.Hour)
+
+	// a month later only b's usage is left
+	clk.Advance(31 * 24 * time.Hour)
+	m.Allow(request("b"), 1)
+	for clk.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mutex.Lock()
+		n := len(m.usage)
+		m.mutex.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d counters kept, want the 2 of b", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// CounterStore keeps request counters that several load balancer instances can share
+type CounterStore interface {
+	// Incr adds n to the counter at key and returns the new total. Counters
+	// expire ttl after their last update; n may be 0 to read a counter.
+	Incr(key string, n int64, ttl time.Duration) (int64, error)
+}
+
+// MemoryCounterStore keeps counters in process. It only shares them between
+// limiters of one instance, which makes it useful for tests and single nodes.
+type MemoryCounterStore struct {
+	counters map[string]*memoryCounter
+	mutex    sync.Mutex
+	lastGC   time.Time
+	clock    clock.Clock
+}
+
+type memoryCounter struct {
+	value   int64
+	expires time.Time
+}
+
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return newMemoryCounterStore(clock.Real)
+}
+
+func newMemoryCounterStore(clk clock.Clock) *MemoryCounterStore {
+	return &MemoryCounterStore{counters: make(map[string]*memoryCounter), lastGC: clk.Now(), clock: clk}
+}
+
+func (m *MemoryCounterStore) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.clock.Now()
+	if now.Sub(m.lastGC) >= time.Minute {
+		for k, c := range m.counters {
+			if now.After(c.expires) {
+				delete(m.counters, k)
+			}
+		}
+		m.lastGC = now
+	}
+
+	c, ok := m.counters[key]
+	if !ok || now.After(c.expires) {
+		c = &memoryCounter{}
+		m.counters[key] = c
+	}
+	c.value += n
+	c.expires = now.Add(ttl)
+	return c.value, nil
+}
@@ -0,0 +1,47 @@
+package ratelimiter
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Decision is the outcome of a rate limit check, with enough detail for
+// clients to back off precisely
+type Decision struct {
+	Allowed    bool
+	Limit      int           // requests allowed in a full window or burst
+	Remaining  int           // requests that would be allowed right now
+	Reset      time.Duration // time until the limit is fully available again
+	RetryAfter time.Duration // time until the next request would be allowed, 0 if allowed
+}
+
+// SetHeaders writes the IETF RateLimit-* fields and, on denial, Retry-After
+func (d Decision) SetHeaders(h http.Header) {
+	if d.Limit <= 0 {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(max(d.Remaining, 0)))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(d.RetryAfter), 1)))
+	}
+}
+
+// ceilSeconds rounds up so clients never retry too early
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// durationFromSeconds converts fractional seconds, e.g. tokens/rate, to a duration
+func durationFromSeconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
@@ -4,35 +4,70 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"golang-load-balancer/clock"
 )
 
 type FixedWindow struct {
-	rate      int // max #requests allowed in a window 
-	count     int // tracks #requests in one time window
+	rate      int           // max #requests allowed in a window
+	window    time.Duration // length of one time window
+	count     int           // tracks #requests in one time window
 	startTime time.Time
+	clock     clock.Clock
 	mutex     sync.Mutex
 }
 
-func NewFixedWindow(rate int) *FixedWindow {
+func NewFixedWindow(rate int, window time.Duration) *FixedWindow {
+	return newFixedWindow(rate, window, clock.Real)
+}
+
+func newFixedWindow(rate int, window time.Duration, clk clock.Clock) *FixedWindow {
 	return &FixedWindow{
 		rate:      rate,
-		startTime: time.Now(),
+		window:    window,
+		startTime: clk.Now(),
+		clock:     clk,
 	}
 }
 
-func (fw *FixedWindow) Allow(r *http.Request) bool {
+func (fw *FixedWindow) Allow(r *http.Request) Decision {
+	return fw.AllowN(r, 1)
+}
+
+func (fw *FixedWindow) AllowN(r *http.Request, n int) Decision {
 	fw.mutex.Lock()
 	defer fw.mutex.Unlock()
 
-	now := time.Now()
-	if now.Sub(fw.startTime) > time.Second {
-		fw.startTime = now
-		fw.count = 0
+	now := fw.clock.Now()
+	fw.advance(now)
+	n = min(n, fw.rate)
+
+	d := Decision{Limit: fw.rate, Reset: fw.startTime.Add(fw.window).Sub(now)}
+	if fw.count+n <= fw.rate {
+		fw.count += n
+		d.Allowed = true
+	} else {
+		d.RetryAfter = d.Reset
 	}
+	d.Remaining = max(fw.rate-fw.count, 0)
+	return d
+}
 
-	if fw.count < fw.rate {
-		fw.count++
-		return true
+func (fw *FixedWindow) Charge(n int) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	if n <= 0 {
+		return
+	}
+	fw.advance(fw.clock.Now())
+	fw.count = min(fw.count+min(n, 2*fw.rate), 2*fw.rate)
+}
+
+// advance starts a new window once the current one is over
+func (fw *FixedWindow) advance(now time.Time) {
+	if now.Sub(fw.startTime) >= fw.window {
+		fw.startTime = now
+		fw.count = 0
 	}
-	return false
 }
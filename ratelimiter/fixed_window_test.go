@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestFixedWindow(t *testing.T) {
+	newWindow := func(clk clock.Clock) Limiter { return newFixedWindow(3, time.Second, clk) }
+
+	runLimiterCases(t, newWindow, []limiterCase{
+		{"burst", []step{
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+			{advance: 400 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 600 * time.Millisecond},
+		}},
+		{"window boundary", []step{
+			{n: 3, allowed: true, remaining: 0},
+			{advance: 999 * time.Millisecond, allowed: false, remaining: 0, retryAfter: time.Millisecond},
+			{advance: time.Millisecond, allowed: true, remaining: 2},
+		}},
+		{"full burst on both sides of a boundary", []step{
+			{advance: 999 * time.Millisecond, n: 3, allowed: true, remaining: 0},
+			{advance: time.Millisecond, n: 3, allowed: true, remaining: 0},
+		}},
+		{"allow n", []step{
+			{n: 2, allowed: true, remaining: 1},
+			{n: 2, allowed: false, remaining: 1, retryAfter: time.Second},
+			{allowed: true, remaining: 0},
+		}},
+		{"cost above rate is capped", []step{
+			{n: 5, allowed: true, remaining: 0},
+		}},
+		{"charge lasts until the window ends", []step{
+			{charge: 5},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+			{advance: time.Second, allowed: true, remaining: 2},
+		}},
+		{"huge charge is capped at one more limit", []step{
+			{charge: math.MaxInt},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+			{advance: time.Second, allowed: true, remaining: 2},
+		}},
+		{"negative charge is ignored", []step{
+			{charge: -5},
+			{allowed: true, remaining: 2},
+		}},
+	})
+}
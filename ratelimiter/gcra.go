@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// GCRA implements the generic cell rate algorithm. Instead of counting tokens
+// it stores one value, the theoretical arrival time (TAT) of the next request,
+// and allows a request if it is not earlier than TAT minus the burst tolerance.
+// Besides the clock it holds only three int64s and updates are lock-free, so
+// per-client instances stay cheap even for millions of clients.
+type GCRA struct {
+	emission  int64        // nanoseconds between requests at the steady rate
+	tolerance int64        // how far ahead of TAT requests may arrive, burst * emission
+	tat       atomic.Int64 // theoretical arrival time in unix nanoseconds
+	clock     clock.Clock
+}
+
+func NewGCRA(rate int, burst int) *GCRA {
+	return newGCRA(rate, burst, clock.Real)
+}
+
+func newGCRA(rate int, burst int, clk clock.Clock) *GCRA {
+	if rate < 1 {
+		rate = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	emission := int64(time.Second) / int64(rate)
+	return &GCRA{
+		emission:  emission,
+		tolerance: emission * int64(burst),
+		clock:     clk,
+	}
+}
+
+func (g *GCRA) Allow(r *http.Request) Decision {
+	return g.AllowN(r, 1)
+}
+
+// AllowN moves TAT forward by n emission intervals
+func (g *GCRA) AllowN(r *http.Request, n int) Decision {
+	increment := min(int64(max(n, 1))*g.emission, g.tolerance)
+	for {
+		now := g.clock.Now().UnixNano()
+		stored := g.tat.Load()
+		tat := max(stored, now) // an idle client starts from now, not from the past
+
+		newTAT := tat + increment
+		if newTAT-g.tolerance > now {
+			// would exceed the burst
+			return g.decision(false, tat, now, time.Duration(newTAT-g.tolerance-now))
+		}
+		if g.tat.CompareAndSwap(stored, newTAT) {
+			return g.decision(true, newTAT, now, 0)
+		}
+		// another request updated TAT concurrently, retry with the new value
+	}
+}
+
+func (g *GCRA) Charge(n int) {
+	if n <= 0 {
+		return
+	}
+	increment := min(int64(n), 2*g.tolerance/g.emission) * g.emission // capped before multiplying, n may be huge
+	for {
+		stored := g.tat.Load()
+		now := g.clock.Now().UnixNano()
+		tat := max(stored, now)
+		if g.tat.CompareAndSwap(stored, min(tat+increment, now+2*g.tolerance)) {
+			return
+		}
+	}
+}
+
+func (g *GCRA) decision(allowed bool, tat int64, now int64, retryAfter time.Duration) Decision {
+	return Decision{
+		Allowed:    allowed,
+		Limit:      int(g.tolerance / g.emission),
+		Remaining:  int((g.tolerance - (tat - now)) / g.emission),
+		Reset:      time.Duration(tat - now),
+		RetryAfter: retryAfter,
+	}
+}
@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestGCRA(t *testing.T) {
+	newGCRALimiter := func(clk clock.Clock) Limiter { return newGCRA(10, 3, clk) }
+
+	runLimiterCases(t, newGCRALimiter, []limiterCase{
+		{"burst", []step{
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: 100 * time.Millisecond},
+		}},
+		{"emission interval", []step{
+			{n: 3, allowed: true, remaining: 0},
+			{advance: 100 * time.Millisecond, allowed: true, remaining: 0},
+			{advance: 50 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 50 * time.Millisecond},
+			{advance: 50 * time.Millisecond, allowed: true, remaining: 0},
+		}},
+		{"idle client starts from now", []step{
+			{n: 3, allowed: true, remaining: 0},
+			{advance: 10 * time.Second, allowed: true, remaining: 2},
+		}},
+		{"allow n", []step{
+			{n: 2, allowed: true, remaining: 1},
+			{n: 2, allowed: false, remaining: 1, retryAfter: 100 * time.Millisecond},
+			{allowed: true, remaining: 0},
+		}},
+		{"cost above burst is capped", []step{
+			{n: 5, allowed: true, remaining: 0},
+		}},
+		{"charge moves the arrival time ahead", []step{
+			{charge: 5},
+			{allowed: false, remaining: -2, retryAfter: 300 * time.Millisecond},
+			{advance: 300 * time.Millisecond, allowed: true, remaining: 0},
+		}},
+		{"huge charge is capped at one more limit", []step{
+			{charge: math.MaxInt},
+			{allowed: false, remaining: -3, retryAfter: 400 * time.Millisecond},
+			{advance: 400 * time.Millisecond, allowed: true, remaining: 0},
+		}},
+		{"negative charge is ignored", []step{
+			{charge: -5},
+			{allowed: true, remaining: 2},
+		}},
+	})
+}
@@ -0,0 +1,71 @@
+package ratelimiter
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	clientIP := func(r *http.Request) string { return "192.0.2.1" }
+	jwt := "Bearer x." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","tenant":"acme","level":3}`)) + ".sig"
+
+	tests := []struct {
+		spec    string
+		headers map[string]string
+		want    string
+	}{
+		{"", nil, "192.0.2.1"},
+		{"ip", nil, "192.0.2.1"},
+		{"header:X-API-Key", map[string]string{"X-API-Key": "k1"}, "k1"},
+		{"header:X-API-Key", nil, ""},
+		{"user", map[string]string{"Authorization": "Basic Ym9iOnNlY3JldA=="}, "bob"},
+		{"user", map[string]string{"Authorization": jwt}, "alice"},
+		{"jwt:tenant", map[string]string{"Authorization": jwt}, "acme"},
+		{"jwt:level", map[string]string{"Authorization": jwt}, "3"},
+		{"jwt:tenant", map[string]string{"Authorization": "Bearer not-a-jwt"}, ""},
+		{"path", nil, "/search"},
+		{"method", nil, "GET"},
+		{"jwt:tenant+path", map[string]string{"Authorization": jwt}, "4:acme7:/search"},
+		{"ip + method", nil, "9:192.0.2.13:GET"},
+	}
+
+	for _, tt := range tests {
+		key, err := ParseKey(tt.spec, clientIP)
+		if err != nil {
+			t.Fatalf("ParseKey(%q): %v", tt.spec, err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/search?q=1", nil)
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		if got := key(r); got != tt.want {
+			t.Errorf("ParseKey(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"header", "jwt:", "cookie:session", "ip+"} {
+		if _, err := ParseKey(spec, clientIP); err == nil {
+			t.Errorf("ParseKey(%q) accepted", spec)
+		}
+	}
+}
+
+func TestCompositeKeysDoNotCollide(t *testing.T) {
+	key, _ := ParseKey("header:A+header:B", nil)
+
+	r1 := httptest.NewRequest(http.MethodGet, "/", nil)
+	r1.Header.Set("A", "x|y")
+	r1.Header.Set("B", "z")
+	r2 := httptest.NewRequest(http.MethodGet, "/", nil)
+	r2.Header.Set("A", "x")
+	r2.Header.Set("B", "y|z")
+	r3 := httptest.NewRequest(http.MethodGet, "/", nil)
+	r3.Header.Set("A", "x:")
+	r3.Header.Set("B", "1:y|z")
+
+	if k1, k2, k3 := key(r1), key(r2), key(r3); k1 == k2 || k2 == k3 || k1 == k3 {
+		t.Errorf("keys collide: %q, %q, %q", k1, k2, k3)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"golang-load-balancer/clock"
 )
 
 type LeakyBucket struct {
@@ -11,22 +13,64 @@ type LeakyBucket struct {
 	rate      float64 // allowed rate at which requests can be processed
 	water     float64 // current #requests in bucket
 	lastCheck time.Time
+	clock     clock.Clock
 	mutex     sync.Mutex
 }
 
 func NewLeakyBucket(rate int, capacity int) *LeakyBucket {
+	return newLeakyBucket(rate, capacity, clock.Real)
+}
+
+func newLeakyBucket(rate int, capacity int, clk clock.Clock) *LeakyBucket {
+	if capacity < 1 {
+		capacity = max(rate, 1)
+	}
 	return &LeakyBucket{
 		capacity:  capacity,
 		rate:      float64(rate),
-		lastCheck: time.Now(),
+		lastCheck: clk.Now(),
+		clock:     clk,
+	}
+}
+
+func (lb *LeakyBucket) Allow(r *http.Request) Decision {
+	return lb.AllowN(r, 1)
+}
+
+func (lb *LeakyBucket) AllowN(r *http.Request, n int) Decision {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	lb.leak()
+	cost := float64(min(max(n, 1), lb.capacity))
+
+	d := Decision{Limit: lb.capacity}
+	if lb.water+cost-1 < float64(lb.capacity) {
+		lb.water += cost
+		d.Allowed = true
+	} else {
+		// wait until enough has leaked out for the request to fit
+		d.RetryAfter = durationFromSeconds((lb.water - float64(lb.capacity) + cost) / lb.rate)
 	}
+	d.Remaining = int(float64(lb.capacity) - lb.water)
+	d.Reset = durationFromSeconds(lb.water / lb.rate)
+	return d
 }
 
-func (lb *LeakyBucket) Allow(r *http.Request) bool {
+func (lb *LeakyBucket) Charge(n int) {
 	lb.mutex.Lock()
 	defer lb.mutex.Unlock()
 
-	now := time.Now()
+	if n <= 0 {
+		return
+	}
+	lb.leak()
+	lb.water = min(lb.water+float64(n), 2*float64(lb.capacity))
+}
+
+// leak drains what has leaked out since the last call, must be called with the lock held
+func (lb *LeakyBucket) leak() {
+	now := lb.clock.Now()
 	elapsed := now.Sub(lb.lastCheck).Seconds()
 	lb.lastCheck = now
 
@@ -34,10 +78,4 @@ func (lb *LeakyBucket) Allow(r *http.Request) bool {
 	if lb.water < 0 {
 		lb.water = 0
 	}
-
-	if lb.water < float64(lb.capacity) {
-		lb.water++
-		return true
-	}
-	return false
 }
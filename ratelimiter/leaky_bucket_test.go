@@ -0,0 +1,66 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestLeakyBucket(t *testing.T) {
+	newBucket := func(clk clock.Clock) Limiter { return newLeakyBucket(10, 3, clk) }
+
+	runLimiterCases(t, newBucket, []limiterCase{
+		{"burst", []step{
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: 100 * time.Millisecond},
+		}},
+		{"leak", []step{
+			{n: 3, allowed: true, remaining: 0},
+			{advance: 100 * time.Millisecond, allowed: true, remaining: 0},
+			{advance: 50 * time.Millisecond, n: 2, allowed: false, remaining: 0, retryAfter: 150 * time.Millisecond},
+			{advance: 150 * time.Millisecond, n: 2, allowed: true, remaining: 0},
+		}},
+		{"leaks until empty", []step{
+			{n: 3, allowed: true, remaining: 0},
+			{advance: 10 * time.Second, allowed: true, remaining: 2},
+		}},
+		{"allow n", []step{
+			{n: 2, allowed: true, remaining: 1},
+			{n: 2, allowed: false, remaining: 1, retryAfter: 100 * time.Millisecond},
+			{allowed: true, remaining: 0},
+		}},
+		{"cost above capacity is capped", []step{
+			{n: 5, allowed: true, remaining: 0},
+		}},
+		{"charge overflows the bucket", []step{
+			{charge: 5},
+			{allowed: false, remaining: -2, retryAfter: 300 * time.Millisecond},
+			{advance: 300 * time.Millisecond, allowed: true, remaining: 0},
+		}},
+		{"huge charge is capped at one more limit", []step{
+			{charge: math.MaxInt},
+			{allowed: false, remaining: -3, retryAfter: 400 * time.Millisecond},
+			{advance: 400 * time.Millisecond, allowed: true, remaining: 0},
+		}},
+		{"negative charge is ignored", []step{
+			{charge: -5},
+			{allowed: true, remaining: 2},
+		}},
+	})
+}
+
+func TestLeakyBucketZeroCapacityUsesRate(t *testing.T) {
+	newBucket := func(clk clock.Clock) Limiter { return newLeakyBucket(2, 0, clk) }
+
+	runLimiterCases(t, newBucket, []limiterCase{
+		{"burst", []step{
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: 500 * time.Millisecond},
+		}},
+	})
+}
@@ -1,19 +1,45 @@
 package ratelimiter
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+
+	"golang-load-balancer/clock"
+)
 
 type Limiter interface {
-	Allow(r *http.Request) bool
+	Allow(r *http.Request) Decision
+	// AllowN admits a request costing n units, taking all of them or none.
+	// Costs above the limit are capped, so expensive requests can still pass.
+	AllowN(r *http.Request, n int) Decision
+	// Charge takes n more units for a request that was already admitted, e.g.
+	// once the backend reports its real cost. It may go past the limit, later
+	// requests then wait until it is paid off. The debt never grows beyond one
+	// more full limit, so a client is not locked out for longer than about two
+	// windows however much it reports. n <= 0 is ignored.
+	Charge(n int)
+}
+
+// NewLimiter builds a limiter by name. rate is per second for token and leaky
+// bucket, and per window for the window based limiters.
+func NewLimiter(algo string, rate int, burst int, window time.Duration) Limiter {
+	return newLimiter(algo, rate, burst, window, clock.Real)
 }
 
-func NewLimiter(algo string, rate int, burst int) Limiter {
+func newLimiter(algo string, rate int, burst int, window time.Duration, clk clock.Clock) Limiter {
 	switch algo {
 	case "token":
-		return NewTokenBucket(rate, burst)
+		return newTokenBucket(rate, burst, clk)
 	case "fixed":
-		return NewFixedWindow(rate)
+		return newFixedWindow(rate, window, clk)
+	case "sliding-log":
+		return newSlidingWindowLog(rate, window, clk)
+	case "sliding-counter":
+		return newSlidingWindowCounter(rate, window, clk)
+	case "gcra":
+		return newGCRA(rate, burst, clk)
 	case "leaky":
-		return NewLeakyBucket(rate, burst)
+		return newLeakyBucket(rate, burst, clk)
 	default:
 		return nil
 	}
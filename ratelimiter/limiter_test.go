@@ -0,0 +1,69 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// epoch is aligned to every window used in the tests, so fixed and shared
+// windows start exactly when the test does
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// step advances the clock, then either charges or checks a request and
+// compares the decision
+type step struct {
+	advance    time.Duration
+	n          int // cost of the request, 1 if zero
+	charge     int // call Charge(charge) instead of AllowN and skip the checks
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+}
+
+type limiterCase struct {
+	name  string
+	steps []step
+}
+
+// runLimiterCases runs every case against a fresh limiter on a fake clock
+func runLimiterCases(t *testing.T, newLimiter func(clk clock.Clock) Limiter, cases []limiterCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := clock.NewFake(epoch)
+			limiter := newLimiter(clk)
+			for i, s := range tc.steps {
+				clk.Advance(s.advance)
+				if s.charge != 0 {
+					limiter.Charge(s.charge)
+					continue
+				}
+				n := max(s.n, 1)
+				d := limiter.AllowN(nil, n)
+				if d.Allowed != s.allowed {
+					t.Fatalf("step %d: allowed = %v, want %v (%+v)", i, d.Allowed, s.allowed, d)
+				}
+				if d.Remaining != s.remaining {
+					t.Errorf("step %d: remaining = %d, want %d", i, d.Remaining, s.remaining)
+				}
+				// rates are converted through float seconds, allow for rounding
+				if diff := d.RetryAfter - s.retryAfter; diff < -time.Microsecond || diff > time.Microsecond {
+					t.Errorf("step %d: retry after = %v, want %v", i, d.RetryAfter, s.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	for _, algo := range []string{"token", "fixed", "sliding-log", "sliding-counter", "gcra", "leaky"} {
+		if NewLimiter(algo, 10, 5, time.Second) == nil {
+			t.Errorf("NewLimiter(%q) = nil", algo)
+		}
+	}
+	if NewLimiter("unknown", 10, 5, time.Second) != nil {
+		t.Error("NewLimiter accepted an unknown algorithm")
+	}
+}
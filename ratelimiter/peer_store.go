@@ -0,0 +1,216 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// PeerSyncPath is where a PeerCounterStore receives the counts of its peers
+const PeerSyncPath = "/ratelimit/sync"
+
+// PeerCounterStore shares counters by gossiping increments between load balancer
+// instances, without a central store. Each instance counts locally and pushes the
+// increments its peers have not seen yet every interval, or as soon as a counter
+// has more than slack unsent increments. Totals include what peers reported, so
+// the cluster can overshoot a limit by roughly slack per instance.
+type PeerCounterStore struct {
+	peers    []string        // base URLs of the other instances
+	allowed  map[string]bool // peer IPs accepted on PeerSyncPath
+	slack    int64
+	interval time.Duration
+	client   *http.Client
+	counters map[string]*peerCounter
+	mutex    sync.Mutex
+	flush    chan struct{}
+	clock    clock.Clock
+
+	closeOnce sync.Once
+	done      chan struct{} // closed by Close
+	stopped   chan struct{} // closed once the sync loop has returned
+}
+
+type peerCounter struct {
+	local   int64 // counted by this instance
+	remote  int64 // reported by peers
+	unsent  int64 // local increments not pushed yet
+	ttl     time.Duration
+	expires time.Time
+}
+
+type peerUpdate struct {
+	Key   string `json:"key"`
+	Delta int64  `json:"delta"`
+	TTL   int64  `json:"ttl_ms"`
+}
+
+// NewPeerCounterStore starts syncing with the given peers, e.g. "http://10.0.0.2:8092"
+// where the other instance serves its -ratelimit-peer-addr listener
+func NewPeerCounterStore(peers []string, slack int64, interval time.Duration) (*PeerCounterStore, error) {
+	return newPeerCounterStore(peers, slack, interval, clock.Real)
+}
+
+func newPeerCounterStore(peers []string, slack int64, interval time.Duration, clk clock.Clock) (*PeerCounterStore, error) {
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+
+	s := &PeerCounterStore{
+		allowed:  make(map[string]bool),
+		slack:    slack,
+		interval: interval,
+		client:   &http.Client{Timeout: time.Second},
+		counters: make(map[string]*peerCounter),
+		flush:    make(chan struct{}, 1),
+		clock:    clk,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, peer := range peers {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+		u, err := url.Parse(peer)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid rate limit peer %q", peer)
+		}
+		ips, err := net.LookupHost(u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("resolving rate limit peer %s: %v", peer, err)
+		}
+		for _, ip := range ips {
+			s.allowed[ip] = true
+		}
+		s.peers = append(s.peers, strings.TrimSuffix(peer, "/"))
+	}
+
+	go s.syncLoop()
+	return s, nil
+}
+
+func (s *PeerCounterStore) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c := s.counter(key, ttl)
+	c.local += n
+	c.unsent += n
+
+	if c.unsent > s.slack || c.unsent < -s.slack {
+		select {
+		case s.flush <- struct{}{}:
+		default: // a push is already pending
+		}
+	}
+	return c.local + c.remote, nil
+}
+
+// counter returns the live counter at key, must be called with the lock held
+func (s *PeerCounterStore) counter(key string, ttl time.Duration) *peerCounter {
+	now := s.clock.Now()
+	c, ok := s.counters[key]
+	if !ok || (now.After(c.expires) && c.unsent == 0) {
+		c = &peerCounter{}
+		s.counters[key] = c
+	}
+	c.ttl = ttl
+	c.expires = now.Add(ttl)
+	return c
+}
+
+func (s *PeerCounterStore) syncLoop() {
+	defer close(s.stopped)
+	for {
+		select {
+		case <-s.clock.After(s.interval):
+		case <-s.flush:
+		case <-s.done:
+			s.push() // hand the last increments over before stopping
+			return
+		}
+		s.push()
+	}
+}
+
+// Close pushes the increments not sent yet and stops syncing with the peers
+func (s *PeerCounterStore) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	<-s.stopped
+}
+
+// push sends unsent increments to every peer. Increments a peer misses while
+// it is down are not resent, the counters expire with their window anyway.
+func (s *PeerCounterStore) push() {
+	var updates []peerUpdate
+
+	s.mutex.Lock()
+	now := s.clock.Now()
+	for key, c := range s.counters {
+		if c.unsent != 0 {
+			updates = append(updates, peerUpdate{Key: key, Delta: c.unsent, TTL: c.ttl.Milliseconds()})
+			c.unsent = 0
+		} else if now.After(c.expires) {
+			delete(s.counters, key)
+		}
+	}
+	s.mutex.Unlock()
+
+	if len(updates) == 0 {
+		return
+	}
+	body, err := json.Marshal(updates)
+	if err != nil {
+		log.Printf("Encoding rate limit sync failed: %v", err)
+		return
+	}
+
+	for _, peer := range s.peers {
+		resp, err := s.client.Post(peer+PeerSyncPath, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Rate limit sync to %s failed: %v", peer, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			log.Printf("Rate limit sync to %s failed: %s", peer, resp.Status)
+		}
+	}
+}
+
+// ServeHTTP receives increments pushed by peers
+func (s *PeerCounterStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if !s.allowed[host] {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var updates []peerUpdate
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	for _, u := range updates {
+		c := s.counter(u.Key, time.Duration(u.TTL)*time.Millisecond)
+		c.remote += u.Delta
+	}
+	s.mutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestPeerCounterStoreSync(t *testing.T) {
+	var a, b *PeerCounterStore
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { a.ServeHTTP(w, r) }))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { b.ServeHTTP(w, r) }))
+	defer serverB.Close()
+
+	var err error
+	if a, err = NewPeerCounterStore([]string{serverB.URL}, 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if b, err = NewPeerCounterStore([]string{serverA.URL}, 5, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// a has no slack, so every increment is pushed right away
+	a.Incr("k", 1, time.Minute)
+	a.Incr("k", 1, time.Minute)
+	waitForCount(t, b, "k", 2)
+
+	// b holds up to 5 increments back before pushing
+	for i := 0; i < 5; i++ {
+		b.Incr("k", 1, time.Minute)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := a.Incr("k", 0, time.Minute); got != 2 {
+		t.Errorf("a sees %d within b's slack, want 2", got)
+	}
+
+	b.Incr("k", 1, time.Minute)
+	waitForCount(t, a, "k", 8)
+}
+
+func TestPeerCounterStoreSyncInterval(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	var b *PeerCounterStore
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { b.ServeHTTP(w, r) }))
+	defer serverB.Close()
+
+	a, err := newPeerCounterStore([]string{serverB.URL}, 100, time.Second, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if b, err = newPeerCounterStore([]string{"http://127.0.0.1:1"}, 100, time.Second, clk); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// within its slack a only pushes when the interval is up on its clock
+	a.Incr("k", 3, time.Minute)
+	for clk.Waiters() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(time.Second)
+	waitForCount(t, b, "k", 3)
+
+	// closing pushes what is left and stops the loop
+	a.Incr("k", 2, time.Minute)
+	a.Close()
+	a.Close()
+	if got, _ := b.Incr("k", 0, time.Minute); got != 5 {
+		t.Errorf("b sees %d after a was closed, want 5", got)
+	}
+}
+
+func TestPeerCounterStoreRejectsUnknownPeers(t *testing.T) {
+	store, err := NewPeerCounterStore([]string{"http://192.0.2.1:8090"}, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	req := httptest.NewRequest(http.MethodPost, PeerSyncPath, nil)
+	req.RemoteAddr = "127.0.0.1:5555"
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func waitForCount(t *testing.T, s *PeerCounterStore, key string, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, _ := s.Incr(key, 0, time.Minute)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("count of %s = %d, want %d", key, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
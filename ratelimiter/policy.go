@@ -0,0 +1,206 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// GlobalKey makes a rule share one bucket between all requests it matches
+const GlobalKey = "global"
+
+// Rule is one rate limit. Its Key decides who shares a bucket: "global" for
+// everyone, or any ParseKey spec such as "ip" or "header:X-API-Key".
+type Rule struct {
+	Name      string   `json:"name"`
+	Algorithm string   `json:"algorithm"`
+	Rate      int      `json:"rate"`
+	Burst     int      `json:"burst,omitempty"`
+	Window    Duration `json:"window,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	Shared    bool     `json:"shared,omitempty"` // count in the shared CounterStore, only for fixed and sliding-counter
+}
+
+// Duration is a time.Duration read from JSON strings like "1s" or "1m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Validate checks the algorithm, rate, burst and key of a rule
+func (rule Rule) Validate() error {
+	if NewLimiter(rule.Algorithm, rule.Rate, rule.Burst, time.Second) == nil {
+		return fmt.Errorf("rule %q: unknown algorithm %q", rule.Name, rule.Algorithm)
+	}
+	if rule.Rate <= 0 {
+		return fmt.Errorf("rule %q: rate must be positive", rule.Name)
+	}
+	// a bucket without room would let nothing through, or everything once costs are capped to it
+	if (rule.Algorithm == "token" || rule.Algorithm == "leaky" || rule.Algorithm == "gcra") && rule.Burst < 1 {
+		return fmt.Errorf("rule %q: %s needs a burst of at least 1", rule.Name, rule.Algorithm)
+	}
+	if rule.Shared && rule.Algorithm != "fixed" && rule.Algorithm != "sliding-counter" {
+		return fmt.Errorf("rule %q: only fixed and sliding-counter limits can be shared", rule.Name)
+	}
+	if rule.Key != GlobalKey {
+		if _, err := ParseKey(rule.Key, nil); err != nil {
+			return fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// CompiledRule is a rule with its key function and the buckets of its clients
+type CompiledRule struct {
+	Rule
+	key   KeyFunc
+	store *Store
+
+	stopEviction func()
+}
+
+// CompileOptions are the settings shared by all rules of a load balancer
+type CompileOptions struct {
+	ClientIP   KeyFunc       // key of rules without one
+	Counters   CounterStore  // counters of shared rules, nil if rules cannot be shared
+	Scope      string        // prefixes shared counter keys, so equally named rules of different routes do not mix
+	MaxClients int           // most keys tracked per rule
+	ClientTTL  time.Duration // forget keys idle for this long, or as long as their limiter still remembers them
+	Clock      clock.Clock   // nil uses the system clock
+}
+
+// CompileRule validates a rule and creates its bucket store
+func CompileRule(rule Rule, opts CompileOptions) (*CompiledRule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+	window := time.Duration(rule.Window)
+	if window <= 0 {
+		window = time.Second
+	}
+
+	clk := clock.OrReal(opts.Clock)
+	limiterFor := func(string) Limiter { return newLimiter(rule.Algorithm, rule.Rate, rule.Burst, window, clk) }
+	if rule.Shared {
+		if opts.Counters == nil {
+			return nil, fmt.Errorf("rule %q is shared but no shared rate limit store is configured", rule.Name)
+		}
+		prefix := opts.Scope + rule.Name + "|"
+		limiterFor = func(key string) Limiter {
+			return newSharedWindow(opts.Counters, prefix+key, rule.Rate, window, rule.Algorithm == "sliding-counter", clk)
+		}
+	}
+
+	maxClients := opts.MaxClients
+	key := func(r *http.Request) string { return GlobalKey }
+	if rule.Key == GlobalKey {
+		maxClients = 1
+	} else {
+		key, _ = ParseKey(rule.Key, opts.ClientIP)
+	}
+
+	ttl := opts.ClientTTL
+	if ttl > 0 {
+		ttl = max(ttl, stateLifetime(rule, window))
+	}
+	store := NewStore(limiterFor, maxClients, ttl)
+	store.clock = clk
+	cr := &CompiledRule{Rule: rule, key: key, store: store}
+	if ttl > 0 {
+		cr.stopEviction = store.StartEviction(ttl / 2)
+	}
+	return cr, nil
+}
+
+// stateLifetime is how long a limiter of rule may still hold state after the
+// client's last request. Forgetting the client sooner would hand it a fresh
+// limit, e.g. after waiting out only part of an hourly window.
+func stateLifetime(rule Rule, window time.Duration) time.Duration {
+	switch rule.Algorithm {
+	case "token", "leaky", "gcra":
+		// refilling a bucket that is one full burst in debt
+		return 2 * time.Duration(max(rule.Burst, 1)) * time.Second / time.Duration(rule.Rate)
+	default:
+		// the sliding counter still weighs the previous window
+		return 2 * window
+	}
+}
+
+// WithKey returns a copy of the rule that shares its buckets but computes keys with key
+func (cr *CompiledRule) WithKey(key KeyFunc) *CompiledRule {
+	return &CompiledRule{Rule: cr.Rule, key: key, store: cr.store, stopEviction: cr.stopEviction}
+}
+
+// Close stops the background eviction of idle clients
+func (cr *CompiledRule) Close() {
+	if cr.stopEviction != nil {
+		cr.stopEviction()
+	}
+}
+
+// Policy evaluates several rules together. Every rule consumes from its own
+// bucket and the request is rejected if any of them denies it.
+type Policy struct {
+	rules []*CompiledRule
+}
+
+func NewPolicy(rules ...*CompiledRule) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Allow checks every rule and returns the most restrictive decision
+func (p *Policy) Allow(r *http.Request) Decision {
+	return p.AllowN(r, 1)
+}
+
+// AllowN checks a request costing n units against every rule
+func (p *Policy) AllowN(r *http.Request, n int) Decision {
+	result := Decision{Allowed: true}
+	for _, rule := range p.rules {
+		d := rule.store.Get(rule.key(r)).AllowN(r, n)
+		result = mostRestrictive(result, d)
+	}
+	return result
+}
+
+// Charge takes n more units from every rule for a request that was already admitted
+func (p *Policy) Charge(r *http.Request, n int) {
+	for _, rule := range p.rules {
+		rule.store.Get(rule.key(r)).Charge(n)
+	}
+}
+
+// mostRestrictive prefers denials with the longest wait, then the fewest remaining requests
+func mostRestrictive(a, b Decision) Decision {
+	switch {
+	case a.Limit <= 0:
+		return b
+	case a.Allowed != b.Allowed:
+		if !b.Allowed {
+			return b
+		}
+		return a
+	case !a.Allowed:
+		if b.RetryAfter > a.RetryAfter {
+			return b
+		}
+		return a
+	case b.Remaining < a.Remaining:
+		return b
+	default:
+		return a
+	}
+}
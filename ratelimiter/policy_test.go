@@ -0,0 +1,70 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		valid bool
+	}{
+		{"token", Rule{Algorithm: "token", Rate: 10, Burst: 5}, true},
+		{"fixed without burst", Rule{Algorithm: "fixed", Rate: 10}, true},
+		{"shared sliding counter", Rule{Algorithm: "sliding-counter", Rate: 10, Shared: true}, true},
+		{"header key", Rule{Algorithm: "fixed", Rate: 10, Key: "header:X-API-Key"}, true},
+		{"unknown algorithm", Rule{Algorithm: "magic", Rate: 10}, false},
+		{"zero rate", Rule{Algorithm: "fixed", Rate: 0}, false},
+		{"token without burst", Rule{Algorithm: "token", Rate: 10}, false},
+		{"leaky without burst", Rule{Algorithm: "leaky", Rate: 10}, false},
+		{"gcra with negative burst", Rule{Algorithm: "gcra", Rate: 10, Burst: -1}, false},
+		{"shared token bucket", Rule{Algorithm: "token", Rate: 10, Burst: 5, Shared: true}, false},
+		{"bad key", Rule{Algorithm: "fixed", Rate: 10, Key: "cookie"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.rule.Validate(); (err == nil) != tc.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestCompileRuleKeepsClientsForTheirWindow(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		idle time.Duration // long enough to be forgotten
+	}{
+		{"window longer than the ttl", Rule{Name: "hourly", Algorithm: "fixed", Rate: 1, Window: Duration(time.Hour)}, 2 * time.Hour},
+		{"sliding log", Rule{Name: "hourly", Algorithm: "sliding-log", Rate: 1, Window: Duration(time.Hour)}, 2 * time.Hour},
+		{"slow bucket", Rule{Name: "slow", Algorithm: "token", Rate: 1, Burst: 3600}, 2 * time.Hour},
+		{"short window keeps the ttl", Rule{Name: "fast", Algorithm: "fixed", Rate: 1}, 10 * time.Minute},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := clock.NewFake(epoch)
+			cr, err := CompileRule(tc.rule, CompileOptions{MaxClients: 10, ClientTTL: 10 * time.Minute, Clock: clk})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cr.Close()
+			cr.key = func(*http.Request) string { return "client" }
+
+			NewPolicy(cr).AllowN(nil, tc.rule.Burst+1)
+			clk.Advance(tc.idle - time.Second)
+			if n := cr.store.EvictIdle(); n != 0 {
+				t.Errorf("client forgotten after %v while its limiter still holds state", tc.idle-time.Second)
+			}
+			clk.Advance(time.Second)
+			if n := cr.store.EvictIdle(); n != 1 {
+				t.Errorf("client kept after %v", tc.idle)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// After a failed dial no new connection is tried for redisRetry, doubling with
+// every further failure up to redisMaxRetry. Requests meanwhile fail right away
+// instead of each paying the dial timeout.
+const (
+	redisRetry    = 500 * time.Millisecond
+	redisMaxRetry = 30 * time.Second
+)
+
+// errRedisDown is returned while dialing is paused after a failure
+var errRedisDown = errors.New("redis: unreachable, not retrying yet")
+
+// RedisCounterStore keeps counters in Redis (or anything speaking its protocol)
+// with INCRBY and PEXPIRE, sent together in one round trip.
+type RedisCounterStore struct {
+	addr     string
+	password string
+	timeout  time.Duration
+	conns    chan *redisConn // idle connections
+	clock    clock.Clock
+
+	mutex   sync.Mutex
+	retryAt time.Time     // no dialing before this
+	backoff time.Duration // pause after the last failed dial, 0 while Redis is reachable
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisCounterStore keeps up to poolSize idle connections to addr. Connections
+// are opened on demand, so the store can be created before Redis is up.
+func NewRedisCounterStore(addr, password string, poolSize int, timeout time.Duration) *RedisCounterStore {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return &RedisCounterStore{
+		addr:     addr,
+		password: password,
+		timeout:  timeout,
+		conns:    make(chan *redisConn, max(poolSize, 1)),
+		clock:    clock.Real,
+	}
+}
+
+func (s *RedisCounterStore) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	conn, err := s.get()
+	if err != nil {
+		return 0, err
+	}
+
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	cmds := appendCommand(nil, "INCRBY", key, strconv.FormatInt(n, 10))
+	cmds = appendCommand(cmds, "PEXPIRE", key, strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	if _, err := conn.Write(cmds); err != nil {
+		conn.Close()
+		return 0, err
+	}
+
+	value, err := readInteger(conn.reader)
+	if err == nil {
+		_, err = readInteger(conn.reader)
+	}
+	if err != nil {
+		conn.Close()
+		return 0, err
+	}
+
+	s.put(conn)
+	return value, nil
+}
+
+func (s *RedisCounterStore) get() (*redisConn, error) {
+	select {
+	case conn := <-s.conns:
+		return conn, nil
+	default:
+	}
+
+	s.mutex.Lock()
+	waiting := s.clock.Now().Before(s.retryAt)
+	s.mutex.Unlock()
+	if waiting {
+		return nil, errRedisDown
+	}
+
+	c, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		s.dialed(false)
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, reader: bufio.NewReader(c)}
+
+	if s.password != "" {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+		conn.Write(appendCommand(nil, "AUTH", s.password))
+		if _, err := readReply(conn.reader); err != nil {
+			conn.Close()
+			s.dialed(false)
+			return nil, fmt.Errorf("redis AUTH: %v", err)
+		}
+	}
+	s.dialed(true)
+	return conn, nil
+}
+
+// dialed resets the backoff after a successful dial and extends it after a failure
+func (s *RedisCounterStore) dialed(ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ok {
+		s.backoff = 0
+		s.retryAt = time.Time{}
+		return
+	}
+	s.backoff = min(max(2*s.backoff, redisRetry), redisMaxRetry)
+	s.retryAt = s.clock.Now().Add(s.backoff)
+}
+
+func (s *RedisCounterStore) put(conn *redisConn) {
+	select {
+	case s.conns <- conn:
+	default:
+		conn.Close() // pool is full
+	}
+}
+
+// appendCommand encodes a command as a RESP array of bulk strings
+func appendCommand(buf []byte, args ...string) []byte {
+	buf = fmt.Appendf(buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return buf
+}
+
+// readReply reads one simple string, error or integer reply
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed reply %q", line)
+	}
+
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+', ':':
+		return line[:len(line)-2], nil
+	case '-':
+		return "", errors.New("redis: " + value)
+	default:
+		return "", fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func readInteger(r *bufio.Reader) (int64, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		return 0, err
+	}
+	if reply[0] != ':' {
+		return 0, fmt.Errorf("redis: expected integer reply, got %q", reply)
+	}
+	return strconv.ParseInt(reply[1:], 10, 64)
+}
@@ -0,0 +1,207 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// fakeRedis understands just enough RESP for RedisCounterStore
+type fakeRedis struct {
+	listener net.Listener
+	counters map[string]int64
+	ttls     map[string]int64
+	mutex    sync.Mutex
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{listener: l, counters: make(map[string]int64), ttls: make(map[string]int64)}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mutex.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "INCRBY":
+			n, _ := strconv.ParseInt(args[2], 10, 64)
+			f.counters[args[1]] += n
+			reply = ":" + strconv.FormatInt(f.counters[args[1]], 10)
+		case "PEXPIRE":
+			ms, _ := strconv.ParseInt(args[2], 10, 64)
+			f.ttls[args[1]] = ms
+			reply = ":1"
+		default:
+			reply = "-ERR unknown command '" + args[0] + "'"
+		}
+		f.mutex.Unlock()
+
+		io.WriteString(conn, reply+"\r\n")
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // $<len>
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestRedisCounterStoreIncr(t *testing.T) {
+	f := startFakeRedis(t)
+	store := NewRedisCounterStore(f.listener.Addr().String(), "", 2, time.Second)
+
+	for i, want := range []int64{1, 3, 3, 2} {
+		n := []int64{1, 2, 0, -1}[i]
+		got, err := store.Incr("k", n, 1500*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Incr #%d: %v", i, err)
+		}
+		if got != want {
+			t.Errorf("Incr #%d = %d, want %d", i, got, want)
+		}
+	}
+	if ttl := f.ttls["k"]; ttl != 1500 {
+		t.Errorf("PEXPIRE ttl = %d, want 1500", ttl)
+	}
+}
+
+func TestRedisCounterStoreError(t *testing.T) {
+	store := NewRedisCounterStore("127.0.0.1:1", "", 1, 100*time.Millisecond)
+	if _, err := store.Incr("k", 1, time.Second); err == nil {
+		t.Fatal("Incr against a closed port succeeded")
+	}
+}
+
+func TestRedisCounterStoreBacksOff(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	store := NewRedisCounterStore("127.0.0.1:1", "", 1, 100*time.Millisecond)
+	store.clock = clk
+
+	steps := []struct {
+		advance  time.Duration
+		wantDown bool // failed without dialing
+	}{
+		{0, false},                     // dial fails, pause for 500ms
+		{0, true},                      // paused
+		{499 * time.Millisecond, true}, // still paused
+		{time.Millisecond, false},      // dial fails again, pause for 1s
+		{999 * time.Millisecond, true},
+		{time.Millisecond, false},
+	}
+	for i, step := range steps {
+		clk.Advance(step.advance)
+		_, err := store.Incr("k", 1, time.Second)
+		if err == nil {
+			t.Fatalf("step %d: Incr against a closed port succeeded", i)
+		}
+		if down := errors.Is(err, errRedisDown); down != step.wantDown {
+			t.Errorf("step %d: error %v, want paused=%v", i, err, step.wantDown)
+		}
+	}
+
+	for range 10 {
+		clk.Advance(redisMaxRetry)
+		store.Incr("k", 1, time.Second)
+	}
+	if store.backoff != redisMaxRetry {
+		t.Errorf("backoff = %v after many failures, want the cap of %v", store.backoff, redisMaxRetry)
+	}
+
+	// once Redis answers again, the pause is forgotten
+	f := startFakeRedis(t)
+	store.addr = f.listener.Addr().String()
+	clk.Advance(redisMaxRetry)
+	if _, err := store.Incr("k", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if store.backoff != 0 {
+		t.Errorf("backoff = %v after a successful dial", store.backoff)
+	}
+}
+
+func TestSharedWindowAcrossInstances(t *testing.T) {
+	f := startFakeRedis(t)
+	addr := f.listener.Addr().String()
+
+	// Two load balancers with their own store and limiter, sharing one Redis
+	a := NewSharedWindow(NewRedisCounterStore(addr, "", 1, time.Second), "client|1.2.3.4", 4, time.Minute, false)
+	b := NewSharedWindow(NewRedisCounterStore(addr, "", 1, time.Second), "client|1.2.3.4", 4, time.Minute, false)
+
+	allowed := 0
+	for i := 0; i < 4; i++ {
+		for _, l := range []*SharedWindow{a, b} {
+			if l.Allow(nil).Allowed {
+				allowed++
+			}
+		}
+	}
+	if allowed != 4 {
+		t.Errorf("allowed %d requests across instances, want 4", allowed)
+	}
+
+	d := a.Allow(nil)
+	if d.Allowed || d.RetryAfter <= 0 {
+		t.Errorf("decision over the limit = %+v, want denied with RetryAfter", d)
+	}
+}
+
+func TestSharedWindowFallsBackLocally(t *testing.T) {
+	sw := NewSharedWindow(NewRedisCounterStore("127.0.0.1:1", "", 1, 50*time.Millisecond), "k", 2, time.Minute, true)
+
+	var allowed int
+	for i := 0; i < 3; i++ {
+		if sw.Allow(nil).Allowed {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("allowed %d requests without a store, want the local limit of 2", allowed)
+	}
+}
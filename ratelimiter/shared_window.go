@@ -0,0 +1,97 @@
+package ratelimiter
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// SharedWindow counts requests in a CounterStore, so every load balancer
+// instance using the same store enforces one combined limit. Windows are
+// aligned to the clock, so instances agree on where a window starts. With
+// sliding set, the previous window is weighted in like SlidingWindowCounter.
+// If the store cannot be reached the request is checked against a local
+// limiter instead, so an outage degrades to per-instance limits.
+type SharedWindow struct {
+	counters CounterStore
+	key      string
+	rate     int
+	window   time.Duration
+	sliding  bool
+	fallback Limiter
+	clock    clock.Clock
+}
+
+func NewSharedWindow(counters CounterStore, key string, rate int, window time.Duration, sliding bool) *SharedWindow {
+	return newSharedWindow(counters, key, rate, window, sliding, clock.Real)
+}
+
+func newSharedWindow(counters CounterStore, key string, rate int, window time.Duration, sliding bool, clk clock.Clock) *SharedWindow {
+	sw := &SharedWindow{counters: counters, key: key, rate: rate, window: window, sliding: sliding, clock: clk}
+	if sliding {
+		sw.fallback = newSlidingWindowCounter(rate, window, clk)
+	} else {
+		sw.fallback = newFixedWindow(rate, window, clk)
+	}
+	return sw
+}
+
+func (sw *SharedWindow) windowKey(start time.Time) string {
+	return sw.key + "|" + strconv.FormatInt(start.UnixMilli(), 10)
+}
+
+func (sw *SharedWindow) Allow(r *http.Request) Decision {
+	return sw.AllowN(r, 1)
+}
+
+func (sw *SharedWindow) AllowN(r *http.Request, n int) Decision {
+	now := sw.clock.Now()
+	start := now.Truncate(sw.window)
+	reset := start.Add(sw.window).Sub(now)
+	ttl := 2 * sw.window // the previous window is still read by sliding limiters
+	n = min(n, sw.rate)
+
+	count, err := sw.counters.Incr(sw.windowKey(start), int64(n), ttl)
+	if err != nil {
+		log.Printf("Shared rate limit store failed, limiting locally: %v", err)
+		return sw.fallback.AllowN(r, n)
+	}
+
+	estimate := float64(count)
+	if sw.sliding {
+		previous, err := sw.counters.Incr(sw.windowKey(start.Add(-sw.window)), 0, ttl)
+		if err == nil {
+			overlap := float64(reset) / float64(sw.window)
+			estimate += float64(previous) * overlap
+		}
+	}
+
+	d := Decision{Limit: sw.rate, Reset: reset}
+	if estimate <= float64(sw.rate) {
+		d.Allowed = true
+		d.Remaining = sw.rate - int(math.Ceil(estimate))
+		return d
+	}
+
+	// Rejected requests should not count against the next window's estimate
+	if sw.sliding {
+		sw.counters.Incr(sw.windowKey(start), -int64(n), ttl)
+	}
+	d.RetryAfter = reset
+	return d
+}
+
+func (sw *SharedWindow) Charge(n int) {
+	if n <= 0 {
+		return
+	}
+	n = min(n, 2*sw.rate)
+	start := sw.clock.Now().Truncate(sw.window)
+	if _, err := sw.counters.Incr(sw.windowKey(start), int64(n), 2*sw.window); err != nil {
+		sw.fallback.Charge(n)
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestSharedWindow(t *testing.T) {
+	newShared := func(sliding bool) func(clk clock.Clock) Limiter {
+		return func(clk clock.Clock) Limiter {
+			return newSharedWindow(newMemoryCounterStore(clk), "test", 3, time.Second, sliding, clk)
+		}
+	}
+
+	t.Run("fixed", func(t *testing.T) {
+		runLimiterCases(t, newShared(false), []limiterCase{
+			{"burst", []step{
+				{allowed: true, remaining: 2},
+				{allowed: true, remaining: 1},
+				{allowed: true, remaining: 0},
+				{allowed: false, remaining: 0, retryAfter: time.Second},
+			}},
+			{"window boundary", []step{
+				{n: 3, allowed: true, remaining: 0},
+				{advance: 999 * time.Millisecond, allowed: false, remaining: 0, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, allowed: true, remaining: 2},
+			}},
+			{"charge", []step{
+				{charge: 3},
+				{allowed: false, remaining: 0, retryAfter: time.Second},
+			}},
+			{"huge charge is capped at one more limit", []step{
+				{charge: math.MaxInt},
+				{allowed: false, remaining: 0, retryAfter: time.Second},
+				{advance: time.Second, allowed: true, remaining: 2},
+			}},
+			{"negative charge is ignored", []step{
+				{charge: -5},
+				{allowed: true, remaining: 2},
+			}},
+		})
+	})
+
+	t.Run("sliding", func(t *testing.T) {
+		runLimiterCases(t, newShared(true), []limiterCase{
+			{"previous window is weighted by its overlap", []step{
+				{n: 3, allowed: true, remaining: 0},
+				// 3 * 0.75 + 1 is above the rate and is not counted
+				{advance: 1250 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 750 * time.Millisecond},
+				// 3 * 0.5 + 1 fits
+				{advance: 250 * time.Millisecond, allowed: true, remaining: 0},
+			}},
+			{"idle for two windows forgets the previous one", []step{
+				{n: 3, allowed: true, remaining: 0},
+				{advance: 2 * time.Second, allowed: true, remaining: 2},
+			}},
+		})
+	})
+}
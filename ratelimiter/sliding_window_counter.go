@@ -0,0 +1,116 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// SlidingWindowCounter approximates a sliding window with two fixed windows:
+// the previous window's count is weighted by how much of it still overlaps
+// the sliding window. It avoids the 2x bursts of FixedWindow at window
+// boundaries while keeping only two counters per client.
+type SlidingWindowCounter struct {
+	rate          int           // max #requests allowed in a window
+	window        time.Duration // length of the window
+	currentStart  time.Time     // start of the current fixed window
+	currentCount  int
+	previousCount int
+	clock         clock.Clock
+	mutex         sync.Mutex
+}
+
+func NewSlidingWindowCounter(rate int, window time.Duration) *SlidingWindowCounter {
+	return newSlidingWindowCounter(rate, window, clock.Real)
+}
+
+func newSlidingWindowCounter(rate int, window time.Duration, clk clock.Clock) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		rate:         rate,
+		window:       window,
+		currentStart: clk.Now(),
+		clock:        clk,
+	}
+}
+
+func (sc *SlidingWindowCounter) Allow(r *http.Request) Decision {
+	return sc.AllowN(r, 1)
+}
+
+func (sc *SlidingWindowCounter) AllowN(r *http.Request, n int) Decision {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	elapsed := sc.advance(sc.clock.Now())
+	n = min(n, sc.rate)
+
+	overlap := float64(sc.window-elapsed) / float64(sc.window)
+	estimate := float64(sc.previousCount)*overlap + float64(sc.currentCount)
+
+	// like a single request, the last unit may start while the estimate is below the rate
+	limit := float64(sc.rate - n + 1)
+
+	d := Decision{Limit: sc.rate}
+	if estimate < limit {
+		sc.currentCount += n
+		estimate += float64(n)
+		d.Allowed = true
+	} else {
+		d.RetryAfter = sc.retryAfter(elapsed, limit)
+	}
+	d.Remaining = int(float64(sc.rate) - estimate)
+	// both windows have slid out once the next window has passed
+	d.Reset = 2*sc.window - elapsed
+	if sc.currentCount == 0 {
+		d.Reset = sc.window - elapsed
+	}
+	return d
+}
+
+func (sc *SlidingWindowCounter) Charge(n int) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if n <= 0 {
+		return
+	}
+	sc.advance(sc.clock.Now())
+	sc.currentCount = min(sc.currentCount+min(n, 2*sc.rate), 2*sc.rate)
+}
+
+// advance moves to the window containing now and returns how far into it now is
+func (sc *SlidingWindowCounter) advance(now time.Time) time.Duration {
+	elapsed := now.Sub(sc.currentStart)
+	if elapsed >= sc.window {
+		windows := elapsed / sc.window
+		if windows == 1 {
+			sc.previousCount = sc.currentCount
+		} else {
+			sc.previousCount = 0 // idle for more than a whole window
+		}
+		sc.currentCount = 0
+		sc.currentStart = sc.currentStart.Add(windows * sc.window)
+		elapsed = now.Sub(sc.currentStart)
+	}
+	return elapsed
+}
+
+// retryAfter finds how long until the weighted estimate drops below rate again
+func (sc *SlidingWindowCounter) retryAfter(elapsed time.Duration, rate float64) time.Duration {
+	window := float64(sc.window)
+
+	// still in this window: wait until enough of the previous window has slid out
+	if current := float64(sc.currentCount); current < rate && sc.previousCount > 0 {
+		overlap := (rate - current) / float64(sc.previousCount)
+		return time.Duration(window*(1-overlap)) - elapsed
+	}
+
+	// next window: the current count becomes the previous one
+	wait := sc.window - elapsed
+	if sc.currentCount > 0 {
+		wait += time.Duration(window * (1 - rate/float64(sc.currentCount)))
+	}
+	return wait
+}
@@ -0,0 +1,57 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestSlidingWindowCounter(t *testing.T) {
+	newCounter := func(clk clock.Clock) Limiter { return newSlidingWindowCounter(4, time.Second, clk) }
+
+	runLimiterCases(t, newCounter, []limiterCase{
+		{"burst", []step{
+			{allowed: true, remaining: 3},
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+		}},
+		{"previous window is weighted by its overlap", []step{
+			{n: 4, allowed: true, remaining: 0},
+			// 4 * 0.9 + 0 = 3.6 is below the rate
+			{advance: 1100 * time.Millisecond, allowed: true, remaining: 0},
+			// 4 * 0.75 + 1 reaches the rate after another 150ms
+			{allowed: false, remaining: 0, retryAfter: 150 * time.Millisecond},
+			{advance: 151 * time.Millisecond, allowed: true, remaining: 0},
+		}},
+		{"idle for two windows forgets the previous one", []step{
+			{n: 4, allowed: true, remaining: 0},
+			{advance: 2500 * time.Millisecond, allowed: true, remaining: 3},
+		}},
+		{"allow n", []step{
+			{n: 2, allowed: true, remaining: 2},
+			{n: 2, allowed: true, remaining: 0},
+			{n: 2, allowed: false, remaining: 0, retryAfter: 1250 * time.Millisecond},
+		}},
+		{"cost above rate is capped", []step{
+			{n: 6, allowed: true, remaining: 0},
+		}},
+		{"charge carries into the next window", []step{
+			{charge: 6},
+			{allowed: false, remaining: -2, retryAfter: time.Second + time.Second/3},
+			{advance: time.Second, allowed: false, remaining: -2, retryAfter: time.Second / 3},
+			{advance: time.Second / 2, allowed: true, remaining: 0},
+		}},
+		{"huge charge is capped at one more limit", []step{
+			{charge: math.MaxInt},
+			{allowed: false, remaining: -4, retryAfter: time.Second + time.Second/2},
+		}},
+		{"negative charge is ignored", []step{
+			{charge: -5},
+			{allowed: true, remaining: 3},
+		}},
+	})
+}
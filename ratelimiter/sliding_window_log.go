@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// SlidingWindowLog remembers the time of every allowed request in the last window,
+// so the limit holds over any window-long interval, not only aligned ones
+type SlidingWindowLog struct {
+	rate   int           // max #requests allowed in any window
+	window time.Duration // length of the sliding window
+	log    []logEntry    // allowed requests, oldest first
+	count  int           // units in the log
+	clock  clock.Clock
+	mutex  sync.Mutex
+}
+
+// logEntry records the units taken at one point in time, so a costly request
+// is one entry instead of one per unit
+type logEntry struct {
+	at    time.Time
+	count int
+}
+
+func NewSlidingWindowLog(rate int, window time.Duration) *SlidingWindowLog {
+	return newSlidingWindowLog(rate, window, clock.Real)
+}
+
+func newSlidingWindowLog(rate int, window time.Duration, clk clock.Clock) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		rate:   rate,
+		window: window,
+		clock:  clk,
+	}
+}
+
+func (sl *SlidingWindowLog) Allow(r *http.Request) Decision {
+	return sl.AllowN(r, 1)
+}
+
+// AllowN logs a request costing n as n requests at the same time
+func (sl *SlidingWindowLog) AllowN(r *http.Request, n int) Decision {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	now := sl.clock.Now()
+	sl.expire(now)
+	n = min(n, sl.rate)
+
+	d := Decision{Limit: sl.rate}
+	if sl.count+n <= sl.rate {
+		sl.append(now, n)
+		d.Allowed = true
+	} else {
+		// enough units free up once the oldest requests slide out
+		excess := sl.count + n - sl.rate
+		for _, e := range sl.log {
+			if excess -= e.count; excess <= 0 {
+				d.RetryAfter = e.at.Add(sl.window).Sub(now)
+				break
+			}
+		}
+	}
+	d.Remaining = max(sl.rate-sl.count, 0)
+	if len(sl.log) > 0 {
+		d.Reset = sl.log[len(sl.log)-1].at.Add(sl.window).Sub(now)
+	}
+	return d
+}
+
+func (sl *SlidingWindowLog) Charge(n int) {
+	if n <= 0 {
+		return
+	}
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	now := sl.clock.Now()
+	sl.expire(now)
+	if n = min(n, 2*sl.rate-sl.count); n > 0 {
+		sl.append(now, n)
+	}
+}
+
+func (sl *SlidingWindowLog) append(now time.Time, n int) {
+	sl.count += n
+	if last := len(sl.log) - 1; last >= 0 && sl.log[last].at.Equal(now) {
+		sl.log[last].count += n
+		return
+	}
+	sl.log = append(sl.log, logEntry{at: now, count: n})
+}
+
+// expire drops requests that slid out of the window
+func (sl *SlidingWindowLog) expire(now time.Time) {
+	cutoff := now.Add(-sl.window)
+	expired := 0
+	for expired < len(sl.log) && !sl.log[expired].at.After(cutoff) {
+		sl.count -= sl.log[expired].count
+		expired++
+	}
+	if expired > 0 {
+		sl.log = append(sl.log[:0], sl.log[expired:]...)
+	}
+}
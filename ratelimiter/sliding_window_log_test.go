@@ -0,0 +1,77 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+func TestSlidingWindowLog(t *testing.T) {
+	newLog := func(clk clock.Clock) Limiter { return newSlidingWindowLog(3, time.Second, clk) }
+
+	runLimiterCases(t, newLog, []limiterCase{
+		{"burst", []step{
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+		}},
+		{"oldest request slides out", []step{
+			{allowed: true, remaining: 2},
+			{advance: 400 * time.Millisecond, allowed: true, remaining: 1},
+			{advance: 400 * time.Millisecond, allowed: true, remaining: 0},
+			{advance: 199 * time.Millisecond, allowed: false, remaining: 0, retryAfter: time.Millisecond},
+			{advance: time.Millisecond, allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: 400 * time.Millisecond},
+		}},
+		{"no double burst at window boundaries", []step{
+			{advance: 999 * time.Millisecond, n: 3, allowed: true, remaining: 0},
+			{advance: time.Millisecond, allowed: false, remaining: 0, retryAfter: 999 * time.Millisecond},
+		}},
+		{"allow n", []step{
+			{n: 2, allowed: true, remaining: 1},
+			{n: 2, allowed: false, remaining: 1, retryAfter: time.Second},
+			{allowed: true, remaining: 0},
+		}},
+		{"cost above rate is capped", []step{
+			{n: 5, allowed: true, remaining: 0},
+		}},
+		{"charge lasts a whole window", []step{
+			{charge: 5},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+			{advance: time.Second, allowed: true, remaining: 2},
+		}},
+		{"huge charge is capped at one more limit", []step{
+			{charge: math.MaxInt},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+			{advance: time.Second, allowed: true, remaining: 2},
+		}},
+		{"negative charge is ignored", []step{
+			{charge: -5},
+			{allowed: true, remaining: 2},
+		}},
+	})
+}
+
+func TestSlidingWindowLogCountsEntries(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	sl := newSlidingWindowLog(3, time.Second, clk)
+
+	// a huge cost must not take memory per unit
+	sl.Charge(math.MaxInt)
+	sl.AllowN(nil, 1)
+	clk.Advance(time.Millisecond)
+	sl.AllowN(nil, 1)
+	if len(sl.log) != 1 || sl.count != 6 {
+		t.Fatalf("log = %+v (count %d), want one entry of 6", sl.log, sl.count)
+	}
+
+	clk.Advance(time.Second)
+	sl.AllowN(nil, 2)
+	sl.AllowN(nil, 1)
+	if len(sl.log) != 1 || sl.count != 3 {
+		t.Errorf("log = %+v (count %d), want requests at one time merged into one entry of 3", sl.log, sl.count)
+	}
+}
@@ -0,0 +1,147 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// storeShards is the most shards a store is split into
+const storeShards = 64
+
+// Store keeps one limiter per client key. It is split into shards so clients
+// rarely contend on the same lock, caps the number of entries by evicting the
+// least recently used ones, and forgets clients idle for longer than ttl.
+type Store struct {
+	shards     []*storeShard
+	newLimiter func(key string) Limiter
+	ttl        time.Duration
+	clock      clock.Clock
+}
+
+type storeShard struct {
+	entries    map[string]*list.Element
+	lru        *list.List // most recently used at the front
+	maxEntries int
+	mutex      sync.Mutex
+}
+
+type storeEntry struct {
+	key      string
+	limiter  Limiter
+	lastUsed time.Time
+}
+
+// NewStore creates a store holding at most maxEntries limiters, built by newLimiter
+// for the key they are first requested with. The cap is split across the shards,
+// so a shard may start evicting before the store as a whole is full.
+// A ttl of 0 keeps idle clients until they are pushed out by the LRU cap.
+func NewStore(newLimiter func(key string) Limiter, maxEntries int, ttl time.Duration) *Store {
+	maxEntries = max(maxEntries, 1)
+	shards := min(maxEntries, storeShards) // every shard holds at least one entry
+
+	s := &Store{shards: make([]*storeShard, shards), newLimiter: newLimiter, ttl: ttl, clock: clock.Real}
+	for i := range s.shards {
+		perShard := maxEntries / shards
+		if i < maxEntries%shards {
+			perShard++
+		}
+		s.shards[i] = &storeShard{
+			entries:    make(map[string]*list.Element),
+			lru:        list.New(),
+			maxEntries: perShard,
+		}
+	}
+	return s
+}
+
+func (s *Store) shard(key string) *storeShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// Get returns the limiter for key, creating it if needed. Lookup and creation
+// happen under one lock, so concurrent first requests share a single limiter.
+func (s *Store) Get(key string) Limiter {
+	sh := s.shard(key)
+	now := s.clock.Now()
+
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if elem, ok := sh.entries[key]; ok {
+		entry := elem.Value.(*storeEntry)
+		entry.lastUsed = now
+		sh.lru.MoveToFront(elem)
+		return entry.limiter
+	}
+
+	entry := &storeEntry{key: key, limiter: s.newLimiter(key), lastUsed: now}
+	sh.entries[key] = sh.lru.PushFront(entry)
+
+	for sh.lru.Len() > sh.maxEntries {
+		sh.removeElement(sh.lru.Back())
+	}
+	return entry.limiter
+}
+
+// Len returns the number of clients currently tracked
+func (s *Store) Len() int {
+	n := 0
+	for _, sh := range s.shards {
+		sh.mutex.Lock()
+		n += sh.lru.Len()
+		sh.mutex.Unlock()
+	}
+	return n
+}
+
+// EvictIdle removes clients not seen for longer than the ttl and returns how many were removed
+func (s *Store) EvictIdle() int {
+	if s.ttl <= 0 {
+		return 0
+	}
+	cutoff := s.clock.Now().Add(-s.ttl)
+
+	evicted := 0
+	for _, sh := range s.shards {
+		sh.mutex.Lock()
+		// the back of the list is the least recently used, stop at the first fresh entry
+		for elem := sh.lru.Back(); elem != nil; elem = sh.lru.Back() {
+			if elem.Value.(*storeEntry).lastUsed.After(cutoff) {
+				break
+			}
+			sh.removeElement(elem)
+			evicted++
+		}
+		sh.mutex.Unlock()
+	}
+	return evicted
+}
+
+// StartEviction runs EvictIdle every interval in the background until stop is called
+func (s *Store) StartEviction(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s.clock.After(interval):
+				s.EvictIdle()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (sh *storeShard) removeElement(elem *list.Element) {
+	sh.lru.Remove(elem)
+	delete(sh.entries, elem.Value.(*storeEntry).key)
+}
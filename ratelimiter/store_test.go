@@ -0,0 +1,177 @@
+package ratelimiter
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang-load-balancer/clock"
+)
+
+// newTestStore creates a store on a fake clock whose limiters allow one request a day
+func newTestStore(maxEntries int, ttl time.Duration) (*Store, *clock.Fake) {
+	clk := clock.NewFake(epoch)
+	s := NewStore(func(string) Limiter { return newFixedWindow(1, 24*time.Hour, clk) }, maxEntries, ttl)
+	s.clock = clk
+	return s, clk
+}
+
+func TestStoreEvictIdle(t *testing.T) {
+	s, clk := newTestStore(100, time.Minute)
+	s.Get("a").Allow(nil)
+	clk.Advance(40 * time.Second)
+	s.Get("b").Allow(nil)
+	clk.Advance(30 * time.Second) // a idle for 70s, b for 30s
+
+	if n := s.EvictIdle(); n != 1 {
+		t.Errorf("evicted %d, want 1", n)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len = %d, want 1", s.Len())
+	}
+	// b keeps its bucket, a starts over
+	if s.Get("b").Allow(nil).Allowed {
+		t.Error("b lost its bucket")
+	}
+	if !s.Get("a").Allow(nil).Allowed {
+		t.Error("a was not forgotten")
+	}
+
+	noTTL, clk := newTestStore(100, 0)
+	noTTL.Get("a")
+	clk.Advance(time.Hour)
+	if n := noTTL.EvictIdle(); n != 0 {
+		t.Errorf("evicted %d without a ttl", n)
+	}
+}
+
+func TestStoreCap(t *testing.T) {
+	for _, maxEntries := range []int{0, 1, 10, 63, 64, 100, 1000} {
+		s, _ := newTestStore(maxEntries, 0)
+		for i := range 10 * max(maxEntries, 1) {
+			s.Get(strconv.Itoa(i))
+		}
+		if s.Len() > max(maxEntries, 1) {
+			t.Errorf("max %d: tracking %d clients", maxEntries, s.Len())
+		}
+		if s.Len() < max(maxEntries, 1)/2 {
+			t.Errorf("max %d: only tracking %d clients", maxEntries, s.Len())
+		}
+	}
+}
+
+func TestStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s, _ := newTestStore(1, 0) // a single shard with room for one client
+	s.Get("a").Allow(nil)
+	s.Get("b").Allow(nil)
+	if !s.Get("a").Allow(nil).Allowed {
+		t.Error("a was kept beyond the cap")
+	}
+
+	s, _ = newTestStore(2*storeShards, 0) // two clients per shard
+	keys := sameShardKeys(s, 3)
+	s.Get(keys[0]).Allow(nil)
+	s.Get(keys[1]).Allow(nil)
+	s.Get(keys[0]) // keys[1] is now the least recently used
+	s.Get(keys[2])
+	if s.Get(keys[0]).Allow(nil).Allowed {
+		t.Error("recently used client was evicted")
+	}
+}
+
+// sameShardKeys returns n keys that hash to the same shard
+func sameShardKeys(s *Store, n int) []string {
+	var keys []string
+	for i := 0; len(keys) < n; i++ {
+		key := strconv.Itoa(i)
+		if s.shard(key) == s.shards[0] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestStoreConcurrentGet(t *testing.T) {
+	var created atomic.Int64
+	s := NewStore(func(string) Limiter {
+		created.Add(1)
+		return NewTokenBucket(1, 1)
+	}, 1000, 0)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 10 {
+				if s.Get("client-" + strconv.Itoa(i)).Allow(nil).Allowed {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// concurrent first requests share one limiter, so each client got exactly one request through
+	if created.Load() != 10 || allowed.Load() != 10 {
+		t.Errorf("created %d limiters and allowed %d requests, want 10 and 10", created.Load(), allowed.Load())
+	}
+}
+
+func TestStoreStopEviction(t *testing.T) {
+	s, clk := newTestStore(100, time.Minute)
+	stop := s.StartEviction(time.Second)
+	s.Get("a")
+
+	waitForWaiters(t, clk, 1)
+	clk.Advance(2 * time.Minute)
+	waitForWaiters(t, clk, 1) // evicted and waiting again
+	if s.Len() != 0 {
+		t.Errorf("Len = %d after eviction, want 0", s.Len())
+	}
+
+	stop()
+	stop() // safe to call twice
+	s.Get("b")
+	clk.Advance(2 * time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if s.Len() != 1 {
+		t.Error("eviction still running after stop")
+	}
+}
+
+func waitForWaiters(t *testing.T, clk *clock.Fake, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for clk.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines waiting on the clock, want %d", clk.Waiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkStoreManyClients(b *testing.B) {
+	const clients = 1_000_000
+
+	keys := make([]string, clients)
+	for i := range keys {
+		keys[i] = "10." + strconv.Itoa(i>>16&0xFF) + "." + strconv.Itoa(i>>8&0xFF) + "." + strconv.Itoa(i&0xFF)
+	}
+
+	store := NewStore(func(string) Limiter { return NewGCRA(100, 10) }, clients/4, time.Minute)
+	var seed atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewSource(seed.Add(1)))
+		for pb.Next() {
+			store.Get(keys[rng.Intn(clients)]).Allow(nil)
+		}
+	})
+}
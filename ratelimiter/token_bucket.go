@@ -4,42 +4,79 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"golang-load-balancer/clock"
 )
 
 type TokenBucket struct {
-	capacity int // equal to burst
-	tokens   int // available to use in bucket
-	rate     int
+	capacity float64 // equal to burst
+	tokens   float64 // available to use in bucket, fractional so slow refills are not lost
+	rate     float64
 	last     time.Time
+	clock    clock.Clock
 	mutex    sync.Mutex
 }
 
 func NewTokenBucket(rate int, capacity int) *TokenBucket {
+	return newTokenBucket(rate, capacity, clock.Real)
+}
+
+func newTokenBucket(rate int, capacity int, clk clock.Clock) *TokenBucket {
+	if capacity < 1 {
+		capacity = max(rate, 1) // an empty bucket would admit nothing, or everything at zero cost
+	}
 	return &TokenBucket{
-		capacity: capacity,
-		tokens:   capacity,
-		rate:     rate,
-		last:     time.Now(),
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		rate:     float64(rate),
+		last:     clk.Now(),
+		clock:    clk,
+	}
+}
+
+func (tb *TokenBucket) Allow(r *http.Request) Decision {
+	return tb.AllowN(r, 1)
+}
+
+func (tb *TokenBucket) AllowN(r *http.Request, n int) Decision {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.refill()
+	cost := min(float64(max(n, 1)), tb.capacity)
+
+	d := Decision{Limit: int(tb.capacity)}
+	if tb.tokens >= cost {
+		tb.tokens -= cost
+		d.Allowed = true
+	} else {
+		d.RetryAfter = durationFromSeconds((cost - tb.tokens) / tb.rate)
 	}
+	d.Remaining = int(tb.tokens)
+	d.Reset = durationFromSeconds((tb.capacity - tb.tokens) / tb.rate)
+	return d
 }
 
-func (tb *TokenBucket) Allow(r *http.Request) bool {
+func (tb *TokenBucket) Charge(n int) {
 	tb.mutex.Lock()
 	defer tb.mutex.Unlock()
 
-	now := time.Now()
+	if n <= 0 {
+		return
+	}
+	tb.refill()
+	// may go negative, the debt is refilled first
+	tb.tokens = max(tb.tokens-float64(n), -tb.capacity)
+}
+
+// refill adds the tokens earned since the last call, must be called with the lock held
+func (tb *TokenBucket) refill() {
+	now := tb.clock.Now()
 	elapsed := now.Sub(tb.last).Seconds()
 	tb.last = now
 
-	// Refill tokens based on elapsed time
-	tb.tokens += int(elapsed * float64(tb.rate))
+	tb.tokens += elapsed * tb.rate
 	if tb.tokens > tb.capacity {
 		tb.tokens = tb.capacity
 	}
-
-	if tb.tokens > 0 {
-		tb.tokens--
-		return true
-	}
-	return false
 }